@@ -0,0 +1,168 @@
+package stm
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// OnReady runs op like Atomically, and calls f with the result once it commits. If op calls Retry,
+// OnReady returns without waiting, and op is run again by whichever goroutine wakes the
+// transaction, so a pending transaction doesn't occupy a goroutine of its own. f is called from
+// that goroutine, or from the caller if op commits straight away, and shouldn't block. cancel
+// abandons a pending transaction and stops it watching Vars. It has no effect after f is called.
+//
+// A panic in op on the first run goes to the caller, as with Atomically. There's no caller to take
+// a panic when op is run again, so the transaction is abandoned, and f is called with a *PanicError
+// instead of a result. err is nil otherwise.
+func OnReady[R any](op Operation[R], f func(ret R, err error)) (cancel func()) {
+	expvars.Add("async atomically", 1)
+	a := &asyncTx[R]{
+		tx: newTx(),
+		op: op,
+		f:  f,
+	}
+	a.tx.onWake = a.wake
+	a.tx.start()
+	if ret, committed := a.run(); committed {
+		f(ret, nil)
+	}
+	return a.cancel
+}
+
+// AtomicallyAsync is OnReady that delivers the result on a channel. If op panics after the first
+// run, the channel is closed without a result. Use OnReady to get the panic.
+func AtomicallyAsync[R any](op Operation[R]) (<-chan R, func()) {
+	ch := make(chan R, 1)
+	cancel := OnReady(op, func(r R, err error) {
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- r
+	})
+	return ch, cancel
+}
+
+// The error OnReady gives for a panic in an Operation run by a goroutine that woke it.
+type PanicError struct {
+	// The value passed to panic.
+	Value any
+	// The stack of the goroutine that recovered it.
+	Stack []byte
+}
+
+func (me *PanicError) Error() string {
+	return fmt.Sprintf("stm: operation panicked: %v", me.Value)
+}
+
+type asyncTx[R any] struct {
+	// Held while the transaction is attempted, and while it's cancelled, as those both change what
+	// it's watching.
+	mu   sync.Mutex
+	done bool
+	tx   *Tx
+	op   Operation[R]
+	f    func(R, error)
+}
+
+// Called by wakeWatchers when an input of the waiting transaction changes.
+func (a *asyncTx[R]) wake() {
+	var ret R
+	var committed bool
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				expvars.Add("async panics", 1)
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		ret, committed = a.run()
+	}()
+	if err != nil {
+		var zero R
+		a.f(zero, err)
+	} else if committed {
+		a.f(ret, nil)
+	}
+}
+
+// Runs the transaction until it commits, or waits. Waiting leaves it in the watchers of the Vars it
+// read, for wakeWatchers to call wake. A panic abandons the transaction on its way through.
+func (a *asyncTx[R]) run() (_ R, committed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			if !a.done {
+				a.finish()
+			}
+			panic(r)
+		}
+	}()
+	tx := a.tx
+	for !a.done {
+		tx.tries++
+		tx.reset()
+		ret, retry := func() (R, bool) {
+			tx.mu.Lock()
+			defer tx.mu.Unlock()
+			return catchRetry(a.op, tx)
+		}()
+		if retry {
+			expvars.Add("retries", 1)
+			if len(tx.reads) == 0 {
+				panic("not waiting on anything")
+			}
+			tx.updateWatchers()
+			tx.mu.Lock()
+			if tx.inputsChanged() {
+				tx.mu.Unlock()
+				continue
+			}
+			// From here, a change to the inputs is the watchers' to act on.
+			expvars.Add("async waits", 1)
+			tx.waiting = true
+			tx.mu.Unlock()
+			return
+		}
+		committed := func() bool {
+			tx.lockAllVars()
+			defer tx.unlock()
+			if tx.inputsChanged() {
+				expvars.Add("failed commits", 1)
+				return false
+			}
+			tx.commit()
+			return true
+		}()
+		if !committed {
+			continue
+		}
+		expvars.Add("commits", 1)
+		a.finish()
+		return ret, true
+	}
+	return
+}
+
+func (a *asyncTx[R]) cancel() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.done {
+		a.finish()
+	}
+}
+
+// Marks the transaction done, and stops it watching anything.
+func (a *asyncTx[R]) finish() {
+	a.done = true
+	tx := a.tx
+	tx.mu.Lock()
+	tx.waiting = false
+	tx.completed = true
+	tx.cond.Broadcast()
+	tx.mu.Unlock()
+	tx.recycle()
+}
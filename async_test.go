@@ -0,0 +1,126 @@
+package stm
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestAtomicallyAsyncReady(t *testing.T) {
+	x := NewVar(1)
+	ch, cancel := AtomicallyAsync(func(tx *Tx) int {
+		return x.Get(tx)
+	})
+	defer cancel()
+	qt.Check(t, qt.Equals(<-ch, 1))
+}
+
+// Many pending transactions don't need a goroutine each, and are all completed by the write they
+// wait for.
+func TestAtomicallyAsyncPending(t *testing.T) {
+	const n = 1000
+	x := NewVar(0)
+	goroutines := runtime.NumGoroutine()
+	chs := make([]<-chan int, 0, n)
+	for i := range n {
+		ch, cancel := AtomicallyAsync(func(tx *Tx) int {
+			cur := x.Get(tx)
+			tx.Assert(cur > 0)
+			return cur + i
+		})
+		defer cancel()
+		chs = append(chs, ch)
+	}
+	qt.Check(t, qt.IsTrue(runtime.NumGoroutine() < goroutines+10))
+	AtomicSet(x, 1)
+	for i, ch := range chs {
+		select {
+		case r := <-ch:
+			qt.Check(t, qt.Equals(r, i+1))
+		case <-time.After(time.Second):
+			t.Fatalf("transaction %v wasn't completed", i)
+		}
+	}
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+}
+
+func TestAtomicallyAsyncCommitsWrites(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	ch, cancel := AtomicallyAsync(VoidOperation(func(tx *Tx) {
+		tx.Assert(x.Get(tx) > 0)
+		y.Set(tx, y.Get(tx)+1)
+	}))
+	defer cancel()
+	AtomicSet(x, 1)
+	<-ch
+	qt.Check(t, qt.Equals(AtomicGet(y), 1))
+}
+
+func TestOnReadyCancel(t *testing.T) {
+	x := NewVar(0)
+	called := false
+	cancel := OnReady(func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 0)
+		return cur
+	}, func(int, error) {
+		called = true
+	})
+	qt.Check(t, qt.Equals(numWatchers(x), 1))
+	cancel()
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+	AtomicSet(x, 1)
+	time.Sleep(10 * time.Millisecond)
+	qt.Check(t, qt.IsFalse(called))
+}
+
+// A panic on the first run goes to the caller, and leaves nothing watching.
+func TestOnReadyPanicOnFirstRun(t *testing.T) {
+	x := NewVar(0)
+	qt.Check(t, qt.PanicMatches(func() {
+		OnReady(func(tx *Tx) int {
+			x.Get(tx)
+			panic("first")
+		}, func(int, error) {
+			t.Error("f called")
+		})
+	}, "first"))
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+}
+
+// A panic when op is run by the goroutine that woke it goes to f, rather than crashing that
+// goroutine, and the transaction is abandoned.
+func TestOnReadyPanicAfterWake(t *testing.T) {
+	x := NewVar(0)
+	errs := make(chan error, 1)
+	OnReady(func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 0)
+		panic("woken")
+	}, func(_ int, err error) {
+		errs <- err
+	})
+	AtomicSet(x, 1)
+	select {
+	case err := <-errs:
+		var pe *PanicError
+		qt.Assert(t, qt.ErrorAs(err, &pe))
+		qt.Check(t, qt.Equals(pe.Value, any("woken")))
+	case <-time.After(time.Second):
+		t.Fatal("f wasn't called")
+	}
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+	// Later writes don't find it.
+	AtomicSet(x, 2)
+	ch, cancel := AtomicallyAsync(func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 2)
+		panic("async")
+	})
+	defer cancel()
+	AtomicSet(x, 3)
+	_, ok := <-ch
+	qt.Check(t, qt.IsFalse(ok))
+}
@@ -0,0 +1,63 @@
+package stm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// The transaction failed to commit too many times because of other transactions committing to
+	// Vars it read.
+	ErrTooManyConflicts = errors.New("too many conflicts")
+	// The transaction didn't commit before the timeout.
+	ErrTimeout = errors.New("timed out")
+	// Returned by atomically when op retries and waiting isn't allowed.
+	errWouldBlock = errors.New("would block")
+)
+
+// The error returned by AtomicallyWith when it gives up on a transaction.
+type AtomicallyError struct {
+	// ErrTooManyConflicts or ErrTimeout.
+	Err error
+	// The number of times the operation was run.
+	Attempts int
+	// The last Var, or other TxVar, whose change caused a commit to fail, or nil if none did.
+	Conflict any
+}
+
+func (me *AtomicallyError) Error() string {
+	return fmt.Sprintf("stm: %v after %v attempts", me.Err, me.Attempts)
+}
+
+func (me *AtomicallyError) Unwrap() error {
+	return me.Err
+}
+
+// Configures AtomicallyWith.
+type AtomicallyOption func(*atomicallyOptions)
+
+type atomicallyOptions struct {
+	maxAttempts int
+	timeout     time.Duration
+	noWait      bool
+	// Stops waiting in Retry when done.
+	ctx context.Context
+}
+
+// MaxAttempts gives up with ErrTooManyConflicts once n attempts to commit have failed because of
+// conflicting commits. Attempts that end in Retry don't count.
+func MaxAttempts(n int) AtomicallyOption {
+	return func(o *atomicallyOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// Timeout gives up with ErrTimeout if the transaction hasn't committed after d, including time
+// spent waiting in Retry.
+func Timeout(d time.Duration) AtomicallyOption {
+	return func(o *atomicallyOptions) {
+		o.timeout = d
+	}
+}
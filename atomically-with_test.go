@@ -0,0 +1,67 @@
+package stm
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func numWatchers(v txVar) (n int) {
+	v.txWatchers().txs.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	return
+}
+
+func TestAtomicallyWithTooManyConflicts(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	_, err := AtomicallyWith(func(tx *Tx) struct{} {
+		y.Set(tx, x.Get(tx)+1)
+		// Conflict with every attempt.
+		AtomicSet(x, AtomicGet(x)+1)
+		return struct{}{}
+	}, MaxAttempts(3))
+	qt.Assert(t, qt.ErrorIs(err, ErrTooManyConflicts))
+	var ae *AtomicallyError
+	qt.Assert(t, qt.ErrorAs(err, &ae))
+	qt.Check(t, qt.Equals(ae.Attempts, 3))
+	qt.Check(t, qt.Equals[any](ae.Conflict, x))
+	qt.Check(t, qt.Equals(AtomicGet(y), 0))
+}
+
+func TestAtomicallyWithTimeout(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	started := time.Now()
+	_, err := AtomicallyWith(func(tx *Tx) struct{} {
+		y.Set(tx, 1)
+		tx.Assert(x.Get(tx) > 0)
+		return struct{}{}
+	}, Timeout(10*time.Millisecond))
+	qt.Assert(t, qt.ErrorIs(err, ErrTimeout))
+	qt.Check(t, qt.IsTrue(time.Since(started) >= 10*time.Millisecond))
+	var ae *AtomicallyError
+	qt.Assert(t, qt.ErrorAs(err, &ae))
+	qt.Check(t, qt.Equals(ae.Attempts, 1))
+	qt.Check(t, qt.IsNil(ae.Conflict))
+	qt.Check(t, qt.Equals(AtomicGet(y), 0))
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+	// Writes after giving up don't find the transaction.
+	AtomicSet(x, 1)
+}
+
+func TestAtomicallyWithCommits(t *testing.T) {
+	x := NewVar(0)
+	go func() {
+		time.Sleep(time.Millisecond)
+		AtomicSet(x, 1)
+	}()
+	ret, err := AtomicallyWith(func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 0)
+		return cur
+	}, MaxAttempts(1), Timeout(time.Minute))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Check(t, qt.Equals(ret, 1))
+}
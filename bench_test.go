@@ -19,6 +19,16 @@ func BenchmarkAtomicSet(b *testing.B) {
 	}
 }
 
+// The cost of a transaction on its own, without contention.
+func BenchmarkAtomicallyIncrement(b *testing.B) {
+	x := NewVar(0)
+	for b.Loop() {
+		Atomically(VoidOperation(func(tx *Tx) {
+			x.Set(tx, x.Get(tx)+1)
+		}))
+	}
+}
+
 func BenchmarkIncrementSTM(b *testing.B) {
 	for b.Loop() {
 		// spawn 1000 goroutines that each increment x by 1
@@ -0,0 +1,73 @@
+package stm
+
+import (
+	"time"
+)
+
+// A Var that becomes true at a deadline, for RetryUntil.
+type deadlineTimer struct {
+	passed *Var[bool]
+	timer  *time.Timer
+}
+
+// RetryUntil calls Retry, unless deadline has passed, in which case it returns. The transaction
+// depends on the deadline, so it's woken when it passes if nothing else wakes it first.
+func (tx *Tx) RetryUntil(deadline time.Time) {
+	if tx.deadlineVar(deadline).Get(tx) {
+		return
+	}
+	tx.Retry()
+}
+
+// Returns a Var for the deadline. It's kept across attempts, so the transaction is woken by the
+// same timer each time it waits, and the timer is stopped when the transaction is done with.
+func (tx *Tx) deadlineVar(deadline time.Time) *Var[bool] {
+	if dt, ok := tx.deadlines[deadline]; ok {
+		return dt.passed
+	}
+	d := time.Until(deadline)
+	dt := deadlineTimer{passed: NewBuiltinEqVar(d <= 0)}
+	if d > 0 {
+		dt.timer = time.AfterFunc(d, func() {
+			AtomicSet(dt.passed, true)
+		})
+	}
+	if tx.deadlines == nil {
+		tx.deadlines = make(map[time.Time]deadlineTimer)
+	}
+	tx.deadlines[deadline] = dt
+	return dt.passed
+}
+
+// Whether reads include the Var for one of tx's deadlines.
+func (tx *Tx) readsDeadline(reads map[txVar]VarValue) bool {
+	for _, dt := range tx.deadlines {
+		if _, ok := reads[dt.passed]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops the timers for deadlines the last attempt didn't depend on, so that a transaction that's
+// rerun many times with different deadlines doesn't accumulate them.
+func (tx *Tx) stopUnreadDeadlines() {
+	for deadline, dt := range tx.deadlines {
+		if _, ok := tx.reads[dt.passed]; ok {
+			continue
+		}
+		if dt.timer != nil {
+			dt.timer.Stop()
+		}
+		delete(tx.deadlines, deadline)
+	}
+}
+
+func (tx *Tx) stopDeadlines() {
+	for deadline, dt := range tx.deadlines {
+		if dt.timer != nil {
+			dt.timer.Stop()
+		}
+		delete(tx.deadlines, deadline)
+	}
+}
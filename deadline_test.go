@@ -0,0 +1,40 @@
+package stm
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestRetryUntil(t *testing.T) {
+	x := NewVar(0)
+	deadline := time.Now().Add(10 * time.Millisecond)
+	attempts := 0
+	ret := Atomically(func(tx *Tx) int {
+		attempts++
+		if x.Get(tx) == 0 {
+			tx.RetryUntil(deadline)
+		}
+		return x.Get(tx)
+	})
+	qt.Check(t, qt.Equals(ret, 0))
+	qt.Check(t, qt.IsFalse(time.Now().Before(deadline)))
+	qt.Check(t, qt.Equals(attempts, 2))
+}
+
+// Another Var changing before the deadline wakes the transaction as usual.
+func TestRetryUntilWokenBeforeDeadline(t *testing.T) {
+	x := NewVar(0)
+	go func() {
+		time.Sleep(time.Millisecond)
+		AtomicSet(x, 1)
+	}()
+	ret := Atomically(func(tx *Tx) int {
+		if x.Get(tx) == 0 {
+			tx.RetryUntil(time.Now().Add(time.Minute))
+		}
+		return x.Get(tx)
+	})
+	qt.Check(t, qt.Equals(ret, 1))
+}
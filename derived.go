@@ -0,0 +1,116 @@
+package stm
+
+import (
+	"maps"
+)
+
+// A value computed from other Vars by an Operation. The result is cached along with the reads it
+// was computed from, and reused by transactions until one of those Vars changes. Reading it makes
+// a transaction depend on the Vars the Operation read, so it can be waited on with Retry like any
+// other Var.
+type DerivedVar[T any] struct {
+	op    Operation[T]
+	cache atomicValue[*derivedCache[T]]
+}
+
+type derivedCache[T any] struct {
+	value T
+	reads map[txVar]VarValue
+}
+
+// Derived returns a DerivedVar for op. op must only depend on the Vars it reads, as it's not run
+// again while they are unchanged.
+func Derived[T any](op Operation[T]) *DerivedVar[T] {
+	return &DerivedVar[T]{op: op}
+}
+
+// Get returns the value of the Operation as of the start of the transaction.
+func (d *DerivedVar[T]) Get(tx *Tx) T {
+	if tx.readOnly {
+		// The cache holds values computed from the latest state, not tx's snapshot.
+		return d.op(tx)
+	}
+	if c := d.cache.Load(); c != nil && c.current() && tx.consistentWith(c.reads) {
+		tx.mergeReads(c.reads)
+		return c.value
+	}
+	// Compute the value from the latest state in a transaction of its own, so that the reads it
+	// needs can be told apart from the rest of tx's.
+	child := newTx()
+	child.reset()
+	// The timers for any RetryUntil in the Operation have to outlive the child, as it's tx that
+	// waits on them.
+	child.deadlines = tx.deadlines
+	value, retry := catchRetry(d.op, child)
+	reads := maps.Clone(child.reads)
+	tx.deadlines, child.deadlines = child.deadlines, nil
+	child.recycle()
+	if !tx.consistentWith(reads) {
+		// tx has written to, or read a different version of, something the Operation depends on.
+		// The cache can't help with that, so run it as part of tx.
+		return d.op(tx)
+	}
+	tx.mergeReads(reads)
+	if retry {
+		tx.Retry()
+	}
+	if !tx.readsDeadline(reads) {
+		// Another transaction would wait on a timer that's stopped when tx is done with.
+		d.cache.Store(&derivedCache[T]{value: value, reads: reads})
+	}
+	return value
+}
+
+// Whether none of the reads have changed since they were made.
+func (c *derivedCache[T]) current() bool {
+	for v, read := range c.reads {
+		if read.Changed(v.currentVarValue()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether reads could have been made by tx without it seeing anything different.
+func (tx *Tx) consistentWith(reads map[txVar]VarValue) bool {
+	for v, read := range reads {
+		if _, ok := tx.writes[v]; ok {
+			return false
+		}
+		if txRead, ok := tx.reads[v]; ok && txRead.Changed(baseVarValue(read)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds reads made elsewhere to the read log, so that tx depends on them too.
+func (tx *Tx) mergeReads(reads map[txVar]VarValue) {
+	for v, read := range reads {
+		existing, ok := tx.reads[v]
+		if !ok {
+			tx.reads[v] = read
+			continue
+		}
+		if _, ok := read.(versionRead); ok {
+			// Depending on the version covers every other way of depending on the value.
+			tx.reads[v] = read
+			continue
+		}
+		existingProjected, ok := existing.(projectedRead)
+		if !ok {
+			// We already depend on the whole value.
+			continue
+		}
+		projected, ok := read.(projectedRead)
+		if !ok {
+			tx.reads[v] = read
+			continue
+		}
+		ps := existingProjected.projections
+		tx.reads[v] = projectedRead{
+			VarValue:    existingProjected.VarValue,
+			projections: append(ps[:len(ps):len(ps)], projected.projections...),
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package stm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func newTestDerived() (a, b *Var[int], sum *DerivedVar[int], computed *atomic.Int32) {
+	a, b = NewVar(1), NewVar(2)
+	computed = new(atomic.Int32)
+	sum = Derived(func(tx *Tx) int {
+		computed.Add(1)
+		return a.Get(tx) + b.Get(tx)
+	})
+	return
+}
+
+func TestDerivedIsCached(t *testing.T) {
+	a, _, sum, computed := newTestDerived()
+	for range 3 {
+		qt.Check(t, qt.Equals(Atomically(sum.Get), 3))
+	}
+	qt.Check(t, qt.Equals(computed.Load(), 1))
+	AtomicSet(a, 10)
+	for range 3 {
+		qt.Check(t, qt.Equals(Atomically(sum.Get), 12))
+	}
+	qt.Check(t, qt.Equals(computed.Load(), 2))
+}
+
+// A transaction that has written an input sees the value derived from what it
+// wrote, without that getting into the cache.
+func TestDerivedSeesOwnWrites(t *testing.T) {
+	a, _, sum, _ := newTestDerived()
+	func() {
+		defer func() { recover() }()
+		Atomically(VoidOperation(func(tx *Tx) {
+			a.Set(tx, 100)
+			qt.Check(t, qt.Equals(sum.Get(tx), 102))
+			panic("abort")
+		}))
+	}()
+	qt.Check(t, qt.Equals(Atomically(sum.Get), 3))
+}
+
+func TestDerivedRetry(t *testing.T) {
+	_, b, sum, _ := newTestDerived()
+	done := make(chan struct{})
+	go func() {
+		Atomically(VoidOperation(func(tx *Tx) {
+			tx.Assert(sum.Get(tx) > 10)
+		}))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	AtomicSet(b, 20)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("transaction waiting on a derived value was not woken by its inputs")
+	}
+}
+
+// Reading an input and then the derived value in a transaction sees one
+// version of the input, even if the input changed in between.
+func TestDerivedConsistentWithTransaction(t *testing.T) {
+	a, _, sum, _ := newTestDerived()
+	attempts := 0
+	Atomically(VoidOperation(func(tx *Tx) {
+		attempts++
+		av := a.Get(tx)
+		if attempts == 1 {
+			AtomicSet(a, 5)
+		}
+		qt.Check(t, qt.Equals(sum.Get(tx), av+2))
+	}))
+	qt.Check(t, qt.Equals(attempts, 2))
+}
+
+func TestDerivedOfDerived(t *testing.T) {
+	a, _, sum, _ := newTestDerived()
+	double := Derived(func(tx *Tx) int {
+		return 2 * sum.Get(tx)
+	})
+	qt.Check(t, qt.Equals(Atomically(double.Get), 6))
+	AtomicSet(a, 2)
+	qt.Check(t, qt.Equals(Atomically(double.Get), 8))
+}
+
+// A read-only transaction reads a Derived as of its snapshot, whatever the cache holds.
+func TestDerivedInReadOnlyTransaction(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	dy := Derived(y.Get)
+	// Cache the current value.
+	qt.Check(t, qt.Equals(Atomically(dy.Get), 0))
+	attempts := 0
+	sum := AtomicallyReadOnly(func(tx *Tx) int {
+		attempts++
+		xv := x.Get(tx)
+		Atomically(VoidOperation(func(tx *Tx) {
+			x.Set(tx, x.Get(tx)-5)
+			y.Set(tx, y.Get(tx)+5)
+		}))
+		// Cache the new value, which the snapshot mustn't see.
+		qt.Check(t, qt.Equals(Atomically(dy.Get), 5))
+		return xv + dy.Get(tx)
+	})
+	qt.Check(t, qt.Equals(attempts, 1))
+	qt.Check(t, qt.Equals(sum, 0))
+}
+
+// The deadline timer for a RetryUntil in the Operation wakes the transaction reading it.
+func TestDerivedRetryUntil(t *testing.T) {
+	deadline := time.Now().Add(20 * time.Millisecond)
+	d := Derived(func(tx *Tx) bool {
+		tx.RetryUntil(deadline)
+		return true
+	})
+	done := make(chan bool)
+	go func() {
+		done <- Atomically(d.Get)
+	}()
+	select {
+	case ret := <-done:
+		qt.Check(t, qt.IsTrue(ret))
+		qt.Check(t, qt.IsFalse(time.Now().Before(deadline)))
+	case <-time.After(10 * time.Second):
+		t.Fatal("transaction wasn't woken at the deadline")
+	}
+}
@@ -2,7 +2,9 @@ package stm_test
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/anacrolix/stm"
 	"github.com/anacrolix/stm/stmutil"
@@ -145,3 +147,103 @@ func BenchmarkInvertedThunderingHerd(b *testing.B) {
 		stm.AtomicSet(done, true)
 	}
 }
+
+// A transactional cell implemented outside the package, with a lock that can
+// be shared between cells.
+type counterCell struct {
+	value    atomic.Pointer[counterValue]
+	mu       *sync.Mutex
+	watchers stm.Watchers
+}
+
+type counterValue struct {
+	n       int64
+	version uint64
+}
+
+func (me counterValue) Set(n any) stm.VarValue {
+	return counterValue{n: n.(int64), version: me.version + 1}
+}
+
+func (me counterValue) Get() any {
+	return me.n
+}
+
+func (me counterValue) Changed(other stm.VarValue) bool {
+	return me.version != other.(counterValue).version
+}
+
+func newCounterCell(mu *sync.Mutex) *counterCell {
+	c := &counterCell{mu: mu}
+	c.value.Store(&counterValue{})
+	return c
+}
+
+func (c *counterCell) LoadVarValue() stm.VarValue {
+	return *c.value.Load()
+}
+
+func (c *counterCell) CommitValue(n any) {
+	old := *c.value.Load()
+	new := old.Set(n).(counterValue)
+	c.value.Store(&new)
+	c.watchers.Wake(c, new)
+}
+
+func (c *counterCell) CommitLock() *sync.Mutex {
+	return c.mu
+}
+
+func (c *counterCell) Watchers() *stm.Watchers {
+	return &c.watchers
+}
+
+func (c *counterCell) get(tx *stm.Tx) int64 {
+	return stm.ReadTxVar(tx, c).(int64)
+}
+
+func (c *counterCell) add(tx *stm.Tx, n int64) {
+	stm.WriteTxVar(tx, c, c.get(tx)+n)
+}
+
+func TestCustomTxVar(t *testing.T) {
+	var mu sync.Mutex
+	a, b := newCounterCell(&mu), newCounterCell(&mu)
+	v := stm.NewVar(0)
+	done := make(chan int64)
+	go func() {
+		done <- stm.Atomically(func(tx *stm.Tx) int64 {
+			sum := a.get(tx) + b.get(tx)
+			tx.Assert(sum >= 100)
+			return sum + int64(v.Get(tx))
+		})
+	}()
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Go(func() {
+			stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+				// Both cells share a lock, which a transaction writing both
+				// must only take once.
+				a.add(tx, 1)
+				b.add(tx, 0)
+				v.Set(tx, v.Get(tx)+1)
+			}))
+		})
+	}
+	wg.Wait()
+	select {
+	case sum := <-done:
+		if sum != 200 {
+			t.Fatalf("got %v, want 200", sum)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transaction waiting on custom cells was not woken")
+	}
+}
+
+// Var doesn't export the methods that would let anything but a transaction commit to it.
+func TestVarIsNotTxVar(t *testing.T) {
+	if _, ok := any(stm.NewVar(0)).(stm.TxVar); ok {
+		t.Fatal("Var implements TxVar")
+	}
+}
@@ -36,7 +36,11 @@ func init() {
 func newTx() *Tx {
 	tx := txPool.Get().(*Tx)
 	tx.tries = 0
+	tx.id.Store(0)
+	tx.started = time.Time{}
 	tx.completed = false
+	tx.readOnly = false
+	tx.onWake = nil
 	return tx
 }
 
@@ -53,15 +57,60 @@ func WouldBlock[R any](fn Operation[R]) (block bool) {
 
 // Atomically executes the atomic function fn.
 func Atomically[R any](op Operation[R]) R {
+	ret, _ := atomicallyTx(nil, op, atomicallyOptions{})
+	return ret
+}
+
+// TryAtomically executes op like Atomically, except that if op calls Retry, it returns false
+// instead of waiting, and nothing is committed. Conflicts with other transactions are still
+// retried, so false means op would block given the state of the Vars it read.
+func TryAtomically[R any](op Operation[R]) (R, bool) {
+	ret, err := atomicallyTx(nil, op, atomicallyOptions{noWait: true})
+	return ret, err == nil
+}
+
+// AtomicallyWith executes op like Atomically, but gives up according to opts. The error is an
+// *AtomicallyError wrapping ErrTooManyConflicts or ErrTimeout, and nothing op wrote is committed.
+func AtomicallyWith[R any](op Operation[R], opts ...AtomicallyOption) (R, error) {
+	var o atomicallyOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return atomicallyTx(nil, op, o)
+}
+
+// Runs op in tx, or in a new transaction that's recycled on return if tx is nil. Returns
+// errWouldBlock if op retried and noWait is set. There's no wrapper for the nil case, as another
+// frame on the way to op is enough to make each new goroutine running Atomically grow its stack.
+//
+// A tx from the caller is theirs to recycle. It's left with the reads of the last attempt, and the
+// timers for any RetryUntil in it, for callers that wait on those themselves. Such callers keep one
+// tx for every run of op, so that what op depended on, timers included, lasts while they wait and
+// until op is run again.
+func atomicallyTx[R any](tx *Tx, op Operation[R], o atomicallyOptions) (_ R, err error) {
 	expvars.Add("atomically", 1)
-	// run the transaction
-	tx := newTx()
-	// A panic that isn't the retry sentinel leaves through here, and the transaction has to stop
-	// watching the Vars it read on the way out. A transaction left in a Var's watchers is never
-	// going to wait or complete, so every later write to that Var strands a wakeWatchers goroutine
-	// on it, and that goroutine blocks the rest of the watchers from being woken at all.
-	defer tx.recycle()
+	if tx == nil {
+		tx = newTx()
+		// A panic that isn't the retry sentinel leaves through here, and the transaction has to
+		// stop watching the Vars it read on the way out. A transaction left in a Var's watchers is
+		// never going to wait or complete, so every later write to that Var strands a wakeWatchers
+		// goroutine on it, and that goroutine blocks the rest of the watchers from being woken at
+		// all.
+		defer tx.recycle()
+	}
+	tx.start()
+	var deadline time.Time
+	if o.timeout != 0 {
+		deadline = time.Now().Add(o.timeout)
+	}
+	conflicts := 0
+	// The last Var whose change made a commit fail.
+	var conflict txVar
 retry:
+	if !deadline.IsZero() && tx.tries != 0 && !time.Now().Before(deadline) {
+		err = tx.giveUp(ErrTimeout, conflict)
+		return
+	}
 	tx.tries++
 	tx.reset()
 	if sleepBetweenRetries {
@@ -83,8 +132,23 @@ retry:
 	}()
 	if retry {
 		expvars.Add("retries", 1)
+		if o.noWait {
+			if tx.inputsChanged() {
+				// op decided to retry based on a state that's already gone.
+				goto retry
+			}
+			err = errWouldBlock
+			return
+		}
 		// wait for one of the variables we read to change before retrying
-		tx.wait()
+		if !tx.waitUntil(o.ctx, deadline) {
+			if o.ctx != nil && o.ctx.Err() != nil {
+				err = tx.giveUp(o.ctx.Err(), conflict)
+			} else {
+				err = tx.giveUp(ErrTimeout, conflict)
+			}
+			return
+		}
 		goto retry
 	}
 	committed := func() bool {
@@ -94,11 +158,12 @@ retry:
 		// and includes calls out to the comparisons a custom Var was made with. A panic in there
 		// would otherwise leave those Vars locked against every future transaction.
 		defer tx.unlock()
-		if tx.inputsChanged() {
+		if v := tx.changedInput(); v != nil {
 			expvars.Add("failed commits", 1)
 			if profileFailedCommits {
 				failedCommitsProfile.Add(new(int), 0)
 			}
+			conflict = v
 			return false
 		}
 		// commit the write log and broadcast that variables have changed
@@ -110,10 +175,27 @@ retry:
 		return true
 	}()
 	if !committed {
+		conflicts++
+		if o.maxAttempts != 0 && conflicts >= o.maxAttempts {
+			err = tx.giveUp(ErrTooManyConflicts, conflict)
+			return
+		}
 		goto retry
 	}
 	expvars.Add("commits", 1)
-	return ret
+	return ret, nil
+}
+
+// Ends a transaction that atomicallyTx gave up on, with the error to return for it. conflict is the
+// last Var whose change made a commit fail, if any.
+func (tx *Tx) giveUp(err error, conflict txVar) error {
+	expvars.Add("atomically gave up", 1)
+	// Release any wakeWatchers that are waiting for the transaction to wait again.
+	tx.mu.Lock()
+	tx.completed = true
+	tx.cond.Broadcast()
+	tx.mu.Unlock()
+	return &AtomicallyError{Err: err, Attempts: tx.tries, Conflict: publicTxVar(conflict)}
 }
 
 // AtomicGet is a helper function that atomically reads a value.
@@ -121,10 +203,57 @@ func AtomicGet[T any](v *Var[T]) T {
 	return fromAny[T](v.value.Load().Get())
 }
 
+// A Var of any type, for Snapshot.
+type AnyVar interface {
+	txVar
+	snapshotVar
+}
+
+var _ AnyVar = (*Var[struct{}])(nil)
+
+// Snapshot reads the values of several Vars as of a single point in time. Unlike reading them with
+// Atomically, it isn't invalidated by commits to them while it runs. It still waits for a Var's
+// commit lock to be free before reading it, so it can be held up by a transaction that's validating
+// or committing to the Var.
+func Snapshot(vars ...AnyVar) []any {
+	return AtomicallyReadOnly(func(tx *Tx) []any {
+		values := make([]any, 0, len(vars))
+		for _, v := range vars {
+			values = append(values, readTxVar(tx, v))
+		}
+		return values
+	})
+}
+
+// AtomicGet2 is Snapshot for two Vars, typed.
+func AtomicGet2[A, B any](a *Var[A], b *Var[B]) (A, B) {
+	type result struct {
+		a A
+		b B
+	}
+	r := AtomicallyReadOnly(func(tx *Tx) result {
+		return result{a.Get(tx), b.Get(tx)}
+	})
+	return r.a, r.b
+}
+
+// AtomicGet3 is Snapshot for three Vars, typed.
+func AtomicGet3[A, B, C any](a *Var[A], b *Var[B], c *Var[C]) (A, B, C) {
+	type result struct {
+		a A
+		b B
+		c C
+	}
+	r := AtomicallyReadOnly(func(tx *Tx) result {
+		return result{a.Get(tx), b.Get(tx), c.Get(tx)}
+	})
+	return r.a, r.b, r.c
+}
+
 // AtomicSet is a helper function that atomically writes a value.
 func AtomicSet[T any](v *Var[T], val T) {
 	v.mu.Lock()
-	v.changeValue(val)
+	v.commitValue(val)
 	v.mu.Unlock()
 }
 
@@ -151,11 +280,11 @@ func Select[R any](fns ...Operation[R]) Operation[R] {
 		case 1:
 			return fns[0](tx)
 		default:
-			oldWrites := tx.writes
-			tx.writes = maps.Clone(oldWrites)
+			oldWrites, oldLocals := tx.writes, tx.locals
+			tx.writes, tx.locals = maps.Clone(oldWrites), maps.Clone(oldLocals)
 			ret, retry := catchRetry(fns[0], tx)
 			if retry {
-				tx.writes = oldWrites
+				tx.writes, tx.locals = oldWrites, oldLocals
 				return Select(fns[1:]...)(tx)
 			} else {
 				return ret
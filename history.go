@@ -0,0 +1,95 @@
+package stm
+
+import (
+	"iter"
+	"slices"
+	"time"
+)
+
+// Configures a Var when it's created.
+type VarOption func(*varOptions)
+
+type varOptions struct {
+	historyLen int
+}
+
+// WithHistory keeps the last n values committed to the Var, including the one it was created with,
+// for inspection with History and At.
+func WithHistory(n int) VarOption {
+	return func(o *varOptions) {
+		o.historyLen = n
+	}
+}
+
+// A value that was committed to a Var.
+type VarHistoryEntry[T any] struct {
+	Value T
+	// Counts the commits to the Var before this one. The value a Var is created with is version 0.
+	Version uint64
+	// When the value was committed.
+	Time time.Time
+}
+
+type varHistory[T any] struct {
+	max int
+	// A ring buffer once it's full, with the oldest entry at start.
+	entries []VarHistoryEntry[T]
+	start   int
+}
+
+func (h *varHistory[T]) record(vv VarValue, t time.Time) {
+	e := VarHistoryEntry[T]{
+		Value:   fromAny[T](vv.Get()),
+		Version: uint64(vv.(versioner).varVersion()),
+		Time:    t,
+	}
+	if len(h.entries) < h.max {
+		h.entries = append(h.entries, e)
+		return
+	}
+	// Overwrite the oldest entry, rather than shifting the rest down under the commit lock.
+	h.entries[h.start] = e
+	h.start = (h.start + 1) % h.max
+}
+
+// Iterates over the entries, oldest first.
+func (h *varHistory[T]) all() iter.Seq[VarHistoryEntry[T]] {
+	return func(yield func(VarHistoryEntry[T]) bool) {
+		for _, e := range h.entries[h.start:] {
+			if !yield(e) {
+				return
+			}
+		}
+		for _, e := range h.entries[:h.start] {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// History returns the values kept for a Var made WithHistory, oldest first.
+func (v *Var[T]) History() []VarHistoryEntry[T] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.history == nil {
+		return nil
+	}
+	return slices.AppendSeq(make([]VarHistoryEntry[T], 0, len(v.history.entries)), v.history.all())
+}
+
+// At returns the value that was committed to the Var at the given version, if it's still in the
+// Var's history.
+func (v *Var[T]) At(version uint64) (_ T, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.history == nil {
+		return
+	}
+	for e := range v.history.all() {
+		if e.Version == version {
+			return e.Value, true
+		}
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package stm
+
+import (
+	"testing"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func historyValues[T any](h []VarHistoryEntry[T]) (values []T, versions []uint64) {
+	for _, e := range h {
+		values = append(values, e.Value)
+		versions = append(versions, e.Version)
+	}
+	return
+}
+
+func TestVarHistory(t *testing.T) {
+	x := NewVar(0, WithHistory(3))
+	values, versions := historyValues(x.History())
+	qt.Check(t, qt.DeepEquals(values, []int{0}))
+	qt.Check(t, qt.DeepEquals(versions, []uint64{0}))
+	for i := 1; i <= 4; i++ {
+		AtomicSet(x, i*10)
+	}
+	Atomically(VoidOperation(func(tx *Tx) {
+		x.Set(tx, x.Get(tx)+1)
+	}))
+	h := x.History()
+	values, versions = historyValues(h)
+	qt.Check(t, qt.DeepEquals(values, []int{30, 40, 41}))
+	qt.Check(t, qt.DeepEquals(versions, []uint64{3, 4, 5}))
+	qt.Check(t, qt.IsFalse(h[2].Time.Before(h[1].Time)))
+	v, ok := x.At(4)
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(v, 40))
+	_, ok = x.At(1)
+	qt.Check(t, qt.IsFalse(ok))
+}
+
+// The history wraps around its buffer any number of times and stays in order.
+func TestVarHistoryWraps(t *testing.T) {
+	x := NewVar(0, WithHistory(4))
+	for i := 1; i <= 10; i++ {
+		AtomicSet(x, i)
+		values, _ := historyValues(x.History())
+		var want []int
+		for j := max(0, i-3); j <= i; j++ {
+			want = append(want, j)
+		}
+		qt.Check(t, qt.DeepEquals(values, want))
+	}
+	v, ok := x.At(7)
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(v, 7))
+}
+
+// Custom Vars keep count of their versions for the history, even though they
+// don't use it to tell whether they've changed.
+func TestCustomVarHistory(t *testing.T) {
+	x := NewBuiltinEqVar("a", WithHistory(10))
+	AtomicSet(x, "b")
+	AtomicSet(x, "b")
+	values, versions := historyValues(x.History())
+	qt.Check(t, qt.DeepEquals(values, []string{"a", "b", "b"}))
+	qt.Check(t, qt.DeepEquals(versions, []uint64{0, 1, 2}))
+}
+
+func TestVarWithoutHistory(t *testing.T) {
+	x := NewVar(0)
+	AtomicSet(x, 1)
+	qt.Check(t, qt.IsNil(x.History()))
+	_, ok := x.At(0)
+	qt.Check(t, qt.IsFalse(ok))
+}
@@ -0,0 +1,29 @@
+// Package stmtest has helpers shared by the tests of stm and the packages built on it. It doesn't
+// import stm, so that stm's own tests can use it.
+package stmtest
+
+// Interrupter is called from each attempt of a transaction under test. On the first attempt it runs
+// interrupt on another goroutine, where it can commit a transaction of its own, and waits for it.
+// Attempts then tells whether that made the transaction run again.
+type Interrupter struct {
+	Attempts  int
+	interrupt func()
+}
+
+func NewInterrupter(interrupt func()) *Interrupter {
+	return &Interrupter{interrupt: interrupt}
+}
+
+// Attempt counts an attempt, and interrupts it if it's the first.
+func (i *Interrupter) Attempt() {
+	i.Attempts++
+	if i.Attempts != 1 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		i.interrupt()
+	}()
+	<-done
+}
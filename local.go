@@ -0,0 +1,41 @@
+package stm
+
+// Value returns the value stored in the transaction for key by SetValue, or nil. Like
+// context.Value, it's for state shared by the operations composed into a transaction, but it only
+// lasts for an attempt: it's cleared when the transaction is retried, and rolled back with the
+// writes of a Select branch that retries. Values should be treated as immutable, as rolling back
+// doesn't copy them.
+func (tx *Tx) Value(key any) any {
+	return tx.locals[key]
+}
+
+// SetValue stores v in the transaction for key, for Value. key must be comparable, and like a
+// context key, should be of a type of its own to avoid collisions.
+func (tx *Tx) SetValue(key, v any) {
+	if tx.locals == nil {
+		tx.locals = make(map[any]any)
+	}
+	tx.locals[key] = v
+}
+
+// A typed key for transaction-local storage. The zero value is not usable, see NewLocal.
+type Local[T any] struct {
+	// Gives each Local a distinct address, so pointers to them make distinct keys.
+	_ byte
+}
+
+// NewLocal returns a new key for transaction-local values of type T.
+func NewLocal[T any]() *Local[T] {
+	return new(Local[T])
+}
+
+// Get returns the value stored for the key in the transaction by Set, if there is one.
+func (l *Local[T]) Get(tx *Tx) (v T, ok bool) {
+	v, ok = tx.Value(l).(T)
+	return
+}
+
+// Set stores v for the key in the transaction, until it's retried.
+func (l *Local[T]) Set(tx *Tx, v T) {
+	tx.SetValue(l, v)
+}
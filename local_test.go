@@ -0,0 +1,65 @@
+package stm
+
+import (
+	"testing"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+// Values are shared by the operations in a transaction, and don't survive a retry.
+func TestLocalResetOnRetry(t *testing.T) {
+	events := NewLocal[[]string]()
+	x := NewVar(0)
+	addEvent := func(tx *Tx, e string) {
+		es, _ := events.Get(tx)
+		events.Set(tx, append(es[:len(es):len(es)], e))
+	}
+	var seen [][]string
+	got := Atomically(func(tx *Tx) []string {
+		es, _ := events.Get(tx)
+		seen = append(seen, es)
+		addEvent(tx, "read")
+		if x.Get(tx) == 0 && len(seen) == 1 {
+			AtomicSet(x, 1)
+		}
+		addEvent(tx, "done")
+		es, _ = events.Get(tx)
+		return es
+	})
+	qt.Check(t, qt.DeepEquals(got, []string{"read", "done"}))
+	qt.Check(t, qt.DeepEquals(seen, [][]string{nil, nil}))
+}
+
+func TestLocalRolledBackBySelect(t *testing.T) {
+	l := NewLocal[int]()
+	got := Atomically(Select(
+		func(tx *Tx) int {
+			l.Set(tx, 1)
+			tx.Retry()
+			return 0
+		},
+		func(tx *Tx) int {
+			v, ok := l.Get(tx)
+			qt.Check(t, qt.IsFalse(ok))
+			l.Set(tx, v+2)
+			v, _ = l.Get(tx)
+			return v
+		},
+	))
+	qt.Check(t, qt.Equals(got, 2))
+}
+
+type valueKey struct{}
+
+func TestTxValue(t *testing.T) {
+	Atomically(VoidOperation(func(tx *Tx) {
+		qt.Check(t, qt.IsNil(tx.Value(valueKey{})))
+		tx.SetValue(valueKey{}, "a")
+		qt.Check(t, qt.Equals(tx.Value(valueKey{}), any("a")))
+		// Distinct Locals of the same type don't collide.
+		a, b := NewLocal[int](), NewLocal[int]()
+		a.Set(tx, 1)
+		_, ok := b.Get(tx)
+		qt.Check(t, qt.IsFalse(ok))
+	}))
+}
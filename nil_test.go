@@ -50,7 +50,7 @@ func TestNilInterfaceVarReadOwnWrite(t *testing.T) {
 	})
 }
 
-// changeValue asserts the new value's type while committing.
+// CommitValue asserts the new value's type while committing.
 func TestNilInterfaceVarSet(t *testing.T) {
 	v := NewVar[error](anError)
 	checkNoPanic(t, func() {
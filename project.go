@@ -0,0 +1,68 @@
+package stm
+
+// GetFunc returns f of the value of v as of the start of the transaction, and makes the
+// transaction depend only on that result. Writes to v that leave f's result the same don't
+// invalidate the transaction, or wake it from Retry. For example, a transaction that only cares
+// whether a queue is empty needn't be rerun for every item added to it. f must be a pure function:
+// it's called again whenever v changes to compare the result.
+func GetFunc[T any, P comparable](tx *Tx, v *Var[T], f func(T) P) P {
+	return GetFuncEq(tx, v, f, func(a, b P) bool {
+		return a == b
+	})
+}
+
+// GetFuncEq is GetFunc for results that are compared by eq instead of ==.
+func GetFuncEq[T, P any](tx *Tx, v *Var[T], f func(T) P, eq func(P, P) bool) P {
+	// If we previously wrote to v, it will be in the write log.
+	if val, ok := tx.writes[v]; ok {
+		return f(fromAny[T](val))
+	}
+	var pr projectedRead
+	switch read := tx.reads[v].(type) {
+	case nil:
+		pr.VarValue = tx.loadVarValue(v)
+	case projectedRead:
+		pr = read
+	default:
+		// We already depend on the whole value.
+		return f(fromAny[T](read.Get()))
+	}
+	ret := f(fromAny[T](pr.Get()))
+	// Copy, so as not to append to a slice shared with the read that's being replaced.
+	pr.projections = append(pr.projections[:len(pr.projections):len(pr.projections)],
+		func(other VarValue) bool {
+			return !eq(ret, f(fromAny[T](other.Get())))
+		})
+	tx.reads[v] = pr
+	return ret
+}
+
+// A read that the transaction only depends on through projections of the value. It stands in for
+// the VarValue that was read in the read log, so that validation and waking only see a change when
+// one of the projections does.
+type projectedRead struct {
+	VarValue
+	// Each reports whether the projection it was made for differs for the given value.
+	projections []func(VarValue) bool
+}
+
+func (me projectedRead) Changed(other VarValue) bool {
+	for _, changed := range me.projections {
+		if changed(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the VarValue that was read, whether it's depended on through projections, its version,
+// or as it is.
+func baseVarValue(vv VarValue) VarValue {
+	switch read := vv.(type) {
+	case projectedRead:
+		return read.VarValue
+	case versionRead:
+		return read.VarValue
+	}
+	return vv
+}
@@ -0,0 +1,90 @@
+package stm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm/internal/stmtest"
+	qt "github.com/go-quicktest/qt"
+)
+
+func isEmpty(s []int) bool { return len(s) == 0 }
+
+// A transaction blocked on a queue being non-empty isn't rerun while writes to
+// the queue leave it empty.
+func TestGetFuncRetryIgnoresUnchangedProjection(t *testing.T) {
+	q := NewVar[[]int](nil)
+	other := NewVar(0)
+	var attempts atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		Atomically(VoidOperation(func(tx *Tx) {
+			attempts.Add(1)
+			tx.Assert(!GetFunc(tx, q, isEmpty))
+		}))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for range 10 {
+		AtomicSet(q, []int{})
+		AtomicSet(other, 1)
+	}
+	time.Sleep(10 * time.Millisecond)
+	qt.Check(t, qt.Equals(attempts.Load(), 1))
+	AtomicSet(q, []int{1})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken when the projection changed")
+	}
+	qt.Check(t, qt.Equals(attempts.Load(), 2))
+}
+
+// Runs a transaction that reads x with read, and is interrupted once by
+// AtomicSet(x, newValue). Returns how many times it ran.
+func readAttempts(x *Var[int], newValue int, read func(*Tx)) int {
+	i := stmtest.NewInterrupter(func() {
+		AtomicSet(x, newValue)
+	})
+	Atomically(VoidOperation(func(tx *Tx) {
+		read(tx)
+		i.Attempt()
+	}))
+	return i.Attempts
+}
+
+func TestGetFuncValidation(t *testing.T) {
+	x := NewVar(1)
+	isOdd := func(i int) bool { return i%2 != 0 }
+	// The parity doesn't change.
+	qt.Check(t, qt.Equals(readAttempts(x, 3, func(tx *Tx) {
+		GetFunc(tx, x, isOdd)
+	}), 1))
+	// The parity changes.
+	qt.Check(t, qt.Equals(readAttempts(x, 4, func(tx *Tx) {
+		GetFunc(tx, x, isOdd)
+	}), 2))
+	// Any one of several projections changing counts.
+	qt.Check(t, qt.Equals(readAttempts(x, 6, func(tx *Tx) {
+		GetFunc(tx, x, isOdd)
+		GetFunc(tx, x, func(i int) bool { return i > 5 })
+	}), 2))
+	// Reading the whole value depends on the whole value, even after a
+	// projection of it, and sees the same version the projection did.
+	qt.Check(t, qt.Equals(readAttempts(x, 8, func(tx *Tx) {
+		even := !GetFunc(tx, x, isOdd)
+		qt.Check(t, qt.Equals(isOdd(x.Get(tx)), !even))
+	}), 2))
+}
+
+func TestGetFuncEqReadsOwnWrite(t *testing.T) {
+	x := NewVar([]int{1})
+	n := Atomically(func(tx *Tx) int {
+		x.Set(tx, []int{1, 2, 3})
+		return GetFuncEq(tx, x, func(s []int) []int { return s }, func(a, b []int) bool {
+			return len(a) == len(b)
+		})[2]
+	})
+	qt.Check(t, qt.Equals(n, 3))
+}
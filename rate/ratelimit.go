@@ -110,7 +110,7 @@ func (rl *Limiter) Wait(ctx context.Context) error {
 }
 
 func (rl *Limiter) WaitN(ctx context.Context, n int) error {
-	ctxDone, cancel := stmutil.ContextDoneVar(ctx)
+	ctxDone, cancel := stmutil.ContextDone(ctx)
 	defer cancel()
 	if err := stm.Atomically(func(tx *stm.Tx) error {
 		if ctxDone.Get(tx) {
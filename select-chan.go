@@ -0,0 +1,78 @@
+package stm
+
+import (
+	"reflect"
+)
+
+// SelectChan blocks until op commits without calling Retry, or one of cases is ready, as
+// reflect.Select would with op as another case. chosen is -1 if op committed, with its result in
+// ret. Otherwise chosen is the index of the case that was, with recv and recvOK as for
+// reflect.Select, and nothing op wrote is committed. Nothing waits in a goroutine while op would
+// block: the Vars it read signal the select when they change. If op retries without reading any
+// Vars, only the cases can be chosen.
+func SelectChan[R any](op Operation[R], cases ...reflect.SelectCase) (chosen int, ret R, recv reflect.Value, recvOK bool) {
+	woke := make(chan struct{}, 1)
+	cases = append(cases[:len(cases):len(cases)], reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(woke),
+	})
+	// One transaction throughout, see atomicallyTx.
+	tx := newTx()
+	defer tx.recycle()
+	tx.onWake = func() {
+		select {
+		case woke <- struct{}{}:
+		default:
+		}
+	}
+	for {
+		r, err := atomicallyTx(tx, op, atomicallyOptions{noWait: true})
+		if err == nil {
+			return -1, r, reflect.Value{}, false
+		}
+		if len(tx.reads) == 0 {
+			// Nothing can change op's mind, which leaves the cases.
+			chosen, recv, recvOK = reflect.Select(cases[:len(cases)-1])
+			return
+		}
+		chosen, recv, recvOK = selectOrWake(tx, cases)
+		if chosen != len(cases)-1 {
+			return
+		}
+	}
+}
+
+// Selects from cases, the last of which receives from the channel that tx's onWake signals. That
+// happens when any of the Vars tx read changes.
+func selectOrWake(tx *Tx, cases []reflect.SelectCase) (int, reflect.Value, bool) {
+	tx.updateWatchers()
+	tx.mu.Lock()
+	if tx.inputsChanged() {
+		tx.mu.Unlock()
+		return len(cases) - 1, reflect.Value{}, false
+	}
+	tx.waiting = true
+	tx.mu.Unlock()
+	chosen, recv, recvOK := reflect.Select(cases)
+	tx.mu.Lock()
+	// If it's woken now, the signal is left for nobody.
+	tx.waiting = false
+	tx.mu.Unlock()
+	return chosen, recv, recvOK
+}
+
+// SelectRecv is SelectChan for a single channel receive. received is true if the receive happened
+// instead of op committing, with v and ok as for a receive from ch.
+func SelectRecv[R, T any](op Operation[R], ch <-chan T) (ret R, v T, ok, received bool) {
+	chosen, ret, recv, ok := SelectChan(op, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ch),
+	})
+	if chosen == -1 {
+		return
+	}
+	if ok {
+		v = recv.Interface().(T)
+	}
+	return ret, v, ok, true
+}
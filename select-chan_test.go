@@ -0,0 +1,115 @@
+package stm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func decWhenPositive(x *Var[int]) Operation[int] {
+	return func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 0)
+		x.Set(tx, cur-1)
+		return cur - 1
+	}
+}
+
+func TestSelectChanOpReady(t *testing.T) {
+	x := NewVar(1)
+	ch := make(chan int, 1)
+	ch <- 1
+	chosen, ret, _, _ := SelectChan(decWhenPositive(x), reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ch),
+	})
+	qt.Check(t, qt.Equals(chosen, -1))
+	qt.Check(t, qt.Equals(ret, 0))
+	qt.Check(t, qt.Equals(len(ch), 1))
+}
+
+func TestSelectChanWokenByVar(t *testing.T) {
+	x := NewVar(0)
+	ch := make(chan int)
+	go func() {
+		time.Sleep(time.Millisecond)
+		AtomicSet(x, 2)
+	}()
+	ret, _, _, received := SelectRecv(decWhenPositive(x), ch)
+	qt.Check(t, qt.IsFalse(received))
+	qt.Check(t, qt.Equals(ret, 1))
+	qt.Check(t, qt.Equals(AtomicGet(x), 1))
+}
+
+// The channel firing first leaves the op's writes uncommitted and the Vars it read unwatched.
+func TestSelectChanChannelFires(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	ch := make(chan int)
+	go func() {
+		time.Sleep(time.Millisecond)
+		ch <- 3
+	}()
+	_, v, ok, received := SelectRecv(func(tx *Tx) int {
+		y.Set(tx, 1)
+		return decWhenPositive(x)(tx)
+	}, ch)
+	qt.Check(t, qt.IsTrue(received))
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(v, 3))
+	qt.Check(t, qt.Equals(AtomicGet(y), 0))
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+	// The op doesn't run after the select is over.
+	AtomicSet(x, 1)
+	time.Sleep(10 * time.Millisecond)
+	qt.Check(t, qt.Equals(AtomicGet(x), 1))
+}
+
+func TestSelectRecvClosed(t *testing.T) {
+	x := NewVar(0)
+	ch := make(chan int)
+	close(ch)
+	_, _, ok, received := SelectRecv(decWhenPositive(x), ch)
+	qt.Check(t, qt.IsTrue(received))
+	qt.Check(t, qt.IsFalse(ok))
+}
+
+// The timer for a RetryUntil in op keeps running while the select waits.
+func TestSelectChanRetryUntil(t *testing.T) {
+	x := NewVar(0)
+	ch := make(chan int)
+	deadline := time.Now().Add(20 * time.Millisecond)
+	done := make(chan int)
+	go func() {
+		ret, _, _, received := SelectRecv(func(tx *Tx) int {
+			if x.Get(tx) == 0 {
+				tx.RetryUntil(deadline)
+				return -1
+			}
+			return x.Get(tx)
+		}, ch)
+		qt.Check(t, qt.IsFalse(received))
+		done <- ret
+	}()
+	select {
+	case ret := <-done:
+		qt.Check(t, qt.Equals(ret, -1))
+		qt.Check(t, qt.IsFalse(time.Now().Before(deadline)))
+	case <-time.After(time.Second):
+		t.Fatal("the deadline didn't wake the select")
+	}
+}
+
+// An op that retries without reading anything leaves the cases to decide.
+func TestSelectChanRetryWithoutReads(t *testing.T) {
+	ch := make(chan int, 1)
+	ch <- 1
+	_, v, ok, received := SelectRecv(func(tx *Tx) int {
+		tx.Retry()
+		return 0
+	}, ch)
+	qt.Check(t, qt.IsTrue(received))
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(v, 1))
+}
@@ -0,0 +1,253 @@
+package stm
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Read-only transactions read Vars as of a snapshot of every Var at the time the transaction
+// started, so that they're never invalidated by commits that come after. While snapshots are
+// active, commits to Vars are numbered by the commit clock, and Vars keep the versions that the
+// oldest snapshot could still need. Otherwise commits cost nothing extra: they don't number
+// themselves or take the global lock, and are stamped with the clock as it stands.
+
+// The maximum number of versions a Var keeps for snapshots, including the current one. A snapshot
+// that needs an older version than this starts again from a newer snapshot.
+const maxVarVersions = 16
+
+var (
+	commitClock atomic.Uint64
+	// Held for reading while numbered Var commits are installed, and for writing while a snapshot
+	// is taken, so that a snapshot sees all of a numbered commit or none of it.
+	commitMu sync.RWMutex
+
+	snapshotsMu sync.Mutex
+	// The number of transactions using each snapshot.
+	snapshots = make(map[uint64]int)
+	// The oldest snapshot in use, or math.MaxUint64 if there are none.
+	oldestSnapshot atomic.Uint64
+)
+
+func init() {
+	oldestSnapshot.Store(math.MaxUint64)
+}
+
+// Returns the commit number for writes to Vars, and whether it's numbered, in which case endCommit
+// must be called when they're installed. The caller holds the commit lock of every Var written.
+//
+// An unnumbered commit can be under way when a snapshot starts. Snapshots take a Var's commit lock
+// to read it, so the snapshot waits for the commit to be installed in any Var it reads that the
+// commit had locked before the snapshot started. Any Var the commit locks afterwards, it locks
+// after the snapshot is registered, and so this would have numbered the commit.
+func beginCommit() (at uint64, numbered bool) {
+	if oldestSnapshot.Load() == math.MaxUint64 {
+		return commitClock.Load(), false
+	}
+	commitMu.RLock()
+	return commitClock.Add(1), true
+}
+
+func endCommit() {
+	commitMu.RUnlock()
+}
+
+func beginSnapshot() uint64 {
+	commitMu.Lock()
+	defer commitMu.Unlock()
+	at := commitClock.Load()
+	snapshotsMu.Lock()
+	snapshots[at]++
+	if at < oldestSnapshot.Load() {
+		oldestSnapshot.Store(at)
+	}
+	snapshotsMu.Unlock()
+	return at
+}
+
+func endSnapshot(at uint64) {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	snapshots[at]--
+	if snapshots[at] != 0 {
+		return
+	}
+	delete(snapshots, at)
+	if at != oldestSnapshot.Load() {
+		return
+	}
+	oldest := uint64(math.MaxUint64)
+	for s := range snapshots {
+		oldest = min(oldest, s)
+	}
+	oldestSnapshot.Store(oldest)
+}
+
+// A value a Var had, and the commit that gave it that value.
+type varVersion struct {
+	value VarValue
+	at    uint64
+	older atomic.Pointer[varVersion]
+}
+
+func (v *varVersion) len() (n int) {
+	for ; v != nil; v = v.older.Load() {
+		n++
+	}
+	return
+}
+
+// Implemented by TxVars that can be read from a snapshot.
+type snapshotVar interface {
+	varValueAt(at uint64) (VarValue, bool)
+}
+
+// Installs a new value for the Var, from the commit numbered at. The caller holds v.mu and is
+// between beginCommit and endCommit.
+func (v *Var[T]) commitValueAt(new any, at uint64) {
+	old := v.value.Load()
+	newVarValue := old.Set(new)
+	oldest := oldestSnapshot.Load()
+	if oldest != math.MaxUint64 {
+		// The versions have to be in place before the value is, see varValueAt.
+		v.pushVersion(old, newVarValue, at, oldest)
+	} else if vs := v.versions.Load(); vs != nil {
+		v.versions.Store(nil)
+		expvars.Add("snapshot versions retained", -int64(vs.len()))
+	}
+	v.value.Store(newVarValue)
+	v.committedAt = at
+	if v.history != nil {
+		v.history.record(newVarValue, time.Now())
+	}
+	if old.Changed(newVarValue) {
+		v.watchers.wake(v, newVarValue)
+	}
+}
+
+func (v *Var[T]) pushVersion(old, new VarValue, at, oldestSnapshot uint64) {
+	older := v.versions.Load()
+	added := 1
+	if older == nil {
+		// Until now no snapshot needed anything but the current value.
+		older = &varVersion{value: old, at: v.committedAt}
+		added++
+	}
+	head := &varVersion{value: new, at: at}
+	head.older.Store(older)
+	// Keep versions back to the one the oldest snapshot would read.
+	last := head
+	for kept := 1; last.at > oldestSnapshot && kept < maxVarVersions; kept++ {
+		next := last.older.Load()
+		if next == nil {
+			break
+		}
+		last = next
+	}
+	removed := last.older.Load().len()
+	last.older.Store(nil)
+	v.versions.Store(head)
+	expvars.Add("snapshot versions retained", int64(added-removed))
+}
+
+// Returns the value the Var had as of the commit numbered at, if it's still available.
+func (v *Var[T]) varValueAt(at uint64) (VarValue, bool) {
+	// Waits out a commit that was under way when the snapshot started, see beginCommit.
+	v.mu.Lock()
+	v.mu.Unlock()
+	for {
+		vs := v.versions.Load()
+		if vs == nil {
+			// No snapshot needed old versions when the current value was committed, so the
+			// snapshot is newer than it. That holds unless the Var was committed to since the
+			// versions were loaded, which would have put versions back in place first.
+			vv := v.value.Load()
+			if v.versions.Load() == nil {
+				return vv, true
+			}
+			continue
+		}
+		for ; vs != nil; vs = vs.older.Load() {
+			if vs.at <= at {
+				return vs.value, true
+			}
+		}
+		return nil, false
+	}
+}
+
+// The panic value for a snapshot that needs a version that's no longer kept. It's a type of its
+// own, as pointers to distinct zero-sized values, like the retry sentinel, can compare equal.
+type snapshotTooOld struct{}
+
+// Returns the value of v that tx should read.
+func (tx *Tx) loadVarValue(v txVar) VarValue {
+	if tx.readOnly {
+		return tx.loadSnapshotVarValue(v)
+	}
+	return v.currentVarValue()
+}
+
+func (tx *Tx) loadSnapshotVarValue(v txVar) VarValue {
+	sv, ok := v.(snapshotVar)
+	if !ok {
+		panic("TxVar can't be read in a read-only transaction")
+	}
+	vv, ok := sv.varValueAt(tx.snapshot)
+	if !ok {
+		panic(snapshotTooOld{})
+	}
+	return vv
+}
+
+// AtomicallyReadOnly executes op reading every Var as of a single point in time, the start of the
+// attempt, so that commits made while it runs don't invalidate it. Long read-only transactions
+// that would rarely commit under Atomically with Vars changing around them always succeed on the
+// first attempt, unless they Retry, or the Vars they read have changed too many times since the
+// attempt started. op must not write to Vars, and can only read Vars, not other TxVars.
+func AtomicallyReadOnly[R any](op Operation[R]) R {
+	expvars.Add("read-only atomically", 1)
+	tx := newTx()
+	tx.readOnly = true
+	tx.start()
+	defer tx.recycle()
+	for {
+		tx.tries++
+		tx.reset()
+		ret, retry, tooOld := runSnapshot(tx, op)
+		if tooOld {
+			expvars.Add("snapshot restarts", 1)
+			continue
+		}
+		if retry {
+			expvars.Add("retries", 1)
+			// The snapshot read versions that may already be out of date, in which case this
+			// returns immediately.
+			tx.wait()
+			continue
+		}
+		tx.mu.Lock()
+		tx.completed = true
+		tx.cond.Broadcast()
+		tx.mu.Unlock()
+		expvars.Add("commits", 1)
+		return ret
+	}
+}
+
+func runSnapshot[R any](tx *Tx, op Operation[R]) (ret R, retry, tooOld bool) {
+	tx.snapshot = beginSnapshot()
+	defer endSnapshot(tx.snapshot)
+	defer func() {
+		if r := recover(); r == (snapshotTooOld{}) {
+			tooOld = true
+		} else if r != nil {
+			panic(r)
+		}
+	}()
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	ret, retry = catchRetry(op, tx)
+	return
+}
@@ -0,0 +1,164 @@
+package stm
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+// A read-only transaction sees the Vars as they were when it started, and
+// isn't rerun for writes made while it runs.
+func TestAtomicallyReadOnlyReadsSnapshot(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	transfer := func(n int) {
+		Atomically(VoidOperation(func(tx *Tx) {
+			x.Set(tx, x.Get(tx)-n)
+			y.Set(tx, y.Get(tx)+n)
+		}))
+	}
+	transfer(1)
+	attempts := 0
+	sum := AtomicallyReadOnly(func(tx *Tx) int {
+		attempts++
+		xv := x.Get(tx)
+		for i := range 5 {
+			transfer(i)
+		}
+		return xv + y.Get(tx)
+	})
+	qt.Check(t, qt.Equals(attempts, 1))
+	qt.Check(t, qt.Equals(sum, 0))
+	qt.Check(t, qt.Equals(AtomicGet(y), 11))
+}
+
+func TestAtomicallyReadOnlyConcurrentWriters(t *testing.T) {
+	const n = 10
+	vars := make([]*Var[int], n)
+	for i := range vars {
+		vars[i] = NewVar(0)
+	}
+	stop := NewVar(false)
+	var writers sync.WaitGroup
+	for i := range n {
+		writers.Go(func() {
+			// Move a unit from one Var to the next until told to stop, so the
+			// total is always zero.
+			for !Atomically(func(tx *Tx) bool {
+				from, to := vars[i], vars[(i+1)%n]
+				from.Set(tx, from.Get(tx)-1)
+				to.Set(tx, to.Get(tx)+1)
+				return stop.Get(tx)
+			}) {
+			}
+		})
+	}
+	for range 100 {
+		qt.Assert(t, qt.Equals(AtomicallyReadOnly(func(tx *Tx) (total int) {
+			for _, v := range vars {
+				total += v.Get(tx)
+			}
+			return
+		}), 0))
+	}
+	AtomicSet(stop, true)
+	writers.Wait()
+}
+
+// A Var only keeps so many versions for snapshots. Needing an older one starts
+// the transaction again from a newer snapshot.
+func TestAtomicallyReadOnlyRestartsWhenVersionsRunOut(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	retained := func() int64 {
+		v, _ := expvar.Get("stm").(*expvar.Map).Get("snapshot versions retained").(*expvar.Int)
+		if v == nil {
+			return 0
+		}
+		return v.Value()
+	}
+	attempts := 0
+	var retainedDuring int64
+	got := AtomicallyReadOnly(func(tx *Tx) int {
+		attempts++
+		x.Get(tx)
+		if attempts == 1 {
+			for i := range maxVarVersions + 1 {
+				AtomicSet(y, i+1)
+			}
+			retainedDuring = retained()
+		}
+		return y.Get(tx)
+	})
+	qt.Check(t, qt.Equals(attempts, 2))
+	qt.Check(t, qt.Equals(got, maxVarVersions+1))
+	qt.Check(t, qt.IsTrue(retainedDuring >= maxVarVersions))
+	// With no snapshots around, the next commit drops what the Var kept.
+	AtomicSet(y, 0)
+	qt.Check(t, qt.IsTrue(retained() < retainedDuring))
+}
+
+func TestAtomicallyReadOnlyRejectsWrites(t *testing.T) {
+	x := NewVar(0)
+	qt.Check(t, qt.PanicMatches(func() {
+		AtomicallyReadOnly(VoidOperation(func(tx *Tx) {
+			x.Set(tx, 1)
+		}))
+	}, "write in read-only transaction"))
+}
+
+func TestSnapshotHelpers(t *testing.T) {
+	a, b, c := NewVar(1), NewVar("two"), NewVar[error](nil)
+	qt.Check(t, qt.DeepEquals(Snapshot(a, b, c), []any{1, "two", nil}))
+	av, bv := AtomicGet2(a, b)
+	qt.Check(t, qt.Equals(av, 1))
+	qt.Check(t, qt.Equals(bv, "two"))
+	AtomicSet(c, anError)
+	av, bv, cv := AtomicGet3(a, b, c)
+	qt.Check(t, qt.Equals(av, 1))
+	qt.Check(t, qt.Equals(bv, "two"))
+	qt.Check(t, qt.Equals(cv, anError))
+}
+
+// The values come from one point in time, even with writers keeping an
+// invariant between the Vars.
+func TestAtomicGet2Consistent(t *testing.T) {
+	x, y := NewVar(0), NewVar(0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			Atomically(VoidOperation(func(tx *Tx) {
+				x.Set(tx, i)
+				y.Set(tx, -i)
+			}))
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		xv, yv := AtomicGet2(x, y)
+		qt.Assert(t, qt.Equals(xv+yv, 0))
+	}
+}
+
+// Commits only number themselves while there are snapshots to tell them apart.
+func TestCommitsUnnumberedWithoutSnapshots(t *testing.T) {
+	x := NewVar(0)
+	before := commitClock.Load()
+	AtomicSet(x, 1)
+	Atomically(VoidOperation(func(tx *Tx) {
+		x.Set(tx, x.Get(tx)+1)
+	}))
+	qt.Check(t, qt.Equals(commitClock.Load(), before))
+	got := AtomicallyReadOnly(func(tx *Tx) int {
+		before := commitClock.Load()
+		AtomicSet(x, 3)
+		qt.Check(t, qt.Equals(commitClock.Load(), before+1))
+		return x.Get(tx)
+	})
+	qt.Check(t, qt.Equals(got, 2))
+}
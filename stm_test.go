@@ -271,3 +271,72 @@ func TestSleepingBeauty(t *testing.T) {
 //		return ret
 //	})
 //}
+
+func TestConst(t *testing.T) {
+	c := Const(42)
+	qt.Check(t, qt.Equals(Atomically(c.Get), 42))
+	// A constant is nothing to wait on.
+	qt.Check(t, qt.PanicMatches(func() {
+		Atomically(VoidOperation(func(tx *Tx) {
+			tx.Assert(c.Get(tx) != 42)
+		}))
+	}, "not waiting on anything"))
+	// Alongside a Var, only the Var is read, locked and watched.
+	x := NewVar(0)
+	done := make(chan struct{})
+	go func() {
+		Atomically(VoidOperation(func(tx *Tx) {
+			tx.Assert(x.Get(tx) == c.Get(tx))
+			qt.Check(t, qt.HasLen(tx.reads, 1))
+		}))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	AtomicSet(x, 42)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("transaction was not woken")
+	}
+}
+
+func TestTryAtomically(t *testing.T) {
+	x := NewVar(0)
+	dec := func(tx *Tx) int {
+		cur := x.Get(tx)
+		tx.Assert(cur > 0)
+		x.Set(tx, cur-1)
+		return cur - 1
+	}
+	_, ok := TryAtomically(dec)
+	qt.Check(t, qt.IsFalse(ok))
+	AtomicSet(x, 2)
+	ret, ok := TryAtomically(dec)
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(ret, 1))
+	// A retry after writing commits nothing.
+	_, ok = TryAtomically(func(tx *Tx) struct{} {
+		x.Set(tx, 100)
+		return tx.Retry()
+	})
+	qt.Check(t, qt.IsFalse(ok))
+	qt.Check(t, qt.Equals(AtomicGet(x), 1))
+}
+
+// A retry decided on a state that changed underneath the transaction is run
+// again, rather than reported.
+func TestTryAtomicallyRerunsStaleRetry(t *testing.T) {
+	x := NewVar(0)
+	attempts := 0
+	_, ok := TryAtomically(func(tx *Tx) struct{} {
+		attempts++
+		cur := x.Get(tx)
+		if attempts == 1 {
+			AtomicSet(x, 1)
+		}
+		tx.Assert(cur > 0)
+		return struct{}{}
+	})
+	qt.Check(t, qt.IsTrue(ok))
+	qt.Check(t, qt.Equals(attempts, 2))
+}
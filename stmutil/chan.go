@@ -0,0 +1,109 @@
+package stmutil
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anacrolix/stm"
+)
+
+// FromChan returns a Queue that's fed the values received from ch, and closed once ch is, or ctx is
+// done. The queue holds as many values as ch does, or one if ch is unbuffered, so senders on ch are
+// held up by a full queue as they would be by a full channel. Feeding stops if the queue is closed
+// by a consumer, dropping the value in hand. The goroutine receiving from ch ends when feeding
+// does.
+func FromChan[T any](ctx context.Context, ch <-chan T) *Queue[T] {
+	q := NewQueue[T](max(cap(ch), 1))
+	go func() {
+		defer stm.Atomically(stm.VoidOperation(q.Close))
+		ctxDone, cancel := ContextDone(ctx)
+		defer cancel()
+		for {
+			var v T
+			var ok bool
+			select {
+			case v, ok = <-ch:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+			if stm.Atomically(func(tx *stm.Tx) (stop bool) {
+				if q.Closed(tx) || ctxDone.Get(tx) {
+					return true
+				}
+				q.Push(tx, v)
+				return false
+			}) {
+				return
+			}
+		}
+	}()
+	return q
+}
+
+// ToChan returns a channel that's sent the values popped from q. The channel is closed once q is
+// closed and empty, or ctx is done. A value popped but not yet received when ctx is done is
+// returned to the front of q.
+func ToChan[T any](ctx context.Context, q *Queue[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		ctxDone, cancel := ContextDone(ctx)
+		defer cancel()
+		for {
+			var v T
+			ok := stm.Atomically(func(tx *stm.Tx) (ok bool) {
+				if ctxDone.Get(tx) {
+					return false
+				}
+				v, ok = q.Pop(tx)
+				return
+			})
+			if !ok {
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+					q.unpop(tx, v)
+				}))
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// SendWhen sends the result of op on ch once op commits, without blocking the caller. op is run as
+// by stm.OnReady, so nothing waits in a goroutine until there's a result to send. cancel abandons
+// op if it hasn't committed, and the send if it hasn't happened, in which case the result is lost.
+// If op panics after its first run, nothing is sent.
+func SendWhen[R any](ch chan<- R, op stm.Operation[R]) (cancel func()) {
+	cancelled := make(chan struct{})
+	stop := stm.OnReady(op, func(r R, err error) {
+		if err != nil {
+			return
+		}
+		select {
+		case ch <- r:
+			return
+		default:
+		}
+		go func() {
+			select {
+			case ch <- r:
+			case <-cancelled:
+			}
+		}()
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(cancelled)
+			stop()
+		})
+	}
+}
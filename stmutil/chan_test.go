@@ -0,0 +1,129 @@
+package stmutil
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm"
+	qt "github.com/go-quicktest/qt"
+)
+
+// Values pass from a channel through a Queue to another channel, and closing the first closes the
+// last, without leaving goroutines behind.
+func TestFromChanToChan(t *testing.T) {
+	in := make(chan int)
+	out := ToChan(context.Background(), FromChan(context.Background(), in))
+	go func() {
+		for i := range 100 {
+			in <- i
+		}
+		close(in)
+	}()
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	qt.Check(t, qt.DeepEquals(got, rangeSlice(100)))
+	waitGoroutinesDone(t, "stmutil.FromChan[")
+	waitGoroutinesDone(t, "stmutil.ToChan[")
+}
+
+// A consumer closing the queue stops the feeding, rather than crashing it.
+func TestFromChanQueueClosed(t *testing.T) {
+	in := make(chan int)
+	q := FromChan(context.Background(), in)
+	in <- 1
+	stm.Atomically(stm.VoidOperation(q.Close))
+	// The queue had room for 1, so the feeder goes back to waiting on in, and only sees the queue is
+	// closed with the next value.
+	in <- 2
+	waitGoroutinesDone(t, "stmutil.FromChan[")
+	qt.Check(t, qt.Equals(stm.Atomically(q.Len), 1))
+}
+
+// Cancelling ctx stops a feeder that's waiting for room in a full queue, and closes the queue.
+func TestFromChanCancelFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	q := FromChan(ctx, in)
+	in <- 1
+	in <- 2
+	cancel()
+	waitGoroutinesDone(t, "stmutil.FromChan[")
+	qt.Check(t, qt.IsTrue(stm.Atomically(q.Closed)))
+	qt.Check(t, qt.Equals(stm.Atomically(q.Len), 1))
+}
+
+// A value that ToChan popped but couldn't send when ctx was done is still in the queue.
+func TestToChanCancel(t *testing.T) {
+	q := NewQueue[int](0)
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		q.Push(tx, 1)
+		q.Push(tx, 2)
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	out := ToChan(ctx, q)
+	qt.Check(t, qt.Equals(<-out, 1))
+	cancel()
+	for range out {
+	}
+	qt.Check(t, qt.Equals(stm.Atomically(q.Len), 1))
+}
+
+func TestSendWhen(t *testing.T) {
+	x := stm.NewVar(0)
+	ch := make(chan int)
+	cancel := SendWhen(ch, func(tx *stm.Tx) int {
+		v := x.Get(tx)
+		tx.Assert(v > 0)
+		return v
+	})
+	defer cancel()
+	stm.AtomicSet(x, 1)
+	select {
+	case v := <-ch:
+		qt.Check(t, qt.Equals(v, 1))
+	case <-time.After(time.Second):
+		t.Fatal("nothing sent")
+	}
+}
+
+func TestSendWhenCancel(t *testing.T) {
+	ch := make(chan int)
+	// Ready straight away, but nothing receives.
+	cancel := SendWhen(ch, func(*stm.Tx) int { return 1 })
+	cancel()
+	waitGoroutinesDone(t, "stmutil.SendWhen[")
+}
+
+// Waits for every goroutine running a function whose name starts with fn, qualified by the package
+// name, to end. Fails the test if any are still running after a generous time.
+func waitGoroutinesDone(t *testing.T, fn string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n == len(buf) {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if !bytes.Contains(buf[:n], []byte(fn)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines in %v still running:\n%s", fn, buf[:n])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func rangeSlice(n int) (ret []int) {
+	for i := range n {
+		ret = append(ret, i)
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package stmutil
 
 import (
+	"cmp"
 	"hash/maphash"
 	"iter"
 
@@ -15,16 +16,43 @@ type KeyConstraint interface {
 	comparable
 }
 
-type Settish[K KeyConstraint] interface {
+// Sets from NewSetWithHasher can hold elements that aren't comparable, so this isn't constrained to
+// KeyConstraint.
+type Settish[K any] interface {
 	Add(K) Settish[K]
 	Delete(K) Settish[K]
 	Contains(K) bool
 	All() iter.Seq[K]
 	Len() int
+	// Returns the set with the elements of other added.
+	Union(other Settish[K]) Settish[K]
+	// Returns the set without the elements that aren't in other.
+	Intersect(other Settish[K]) Settish[K]
+	// Returns the set without the elements that are in other.
+	Difference(other Settish[K]) Settish[K]
+	// Reports whether every element of the set is in other.
+	IsSubset(other Settish[K]) bool
 }
 
-type mapToSet[K KeyConstraint] struct {
+// The Settish returned by NewSortedSet implements this too.
+type SortedSettish[K KeyConstraint] interface {
+	Settish[K]
+	Min() (K, bool)
+	Max() (K, bool)
+	// Iterates in order from the first element that isn't less than the one given.
+	Seek(K) iter.Seq[K]
+	// Iterates in reverse order.
+	Backward() iter.Seq[K]
+}
+
+type mapToSet[K any] struct {
 	m Mappish[K, struct{}]
+	// An empty map of the same kind as m, for building results from nothing in this set's kind.
+	empty Mappish[K, struct{}]
+}
+
+func newMapToSet[K any](empty Mappish[K, struct{}]) mapToSet[K] {
+	return mapToSet[K]{empty, empty}
 }
 
 type interhash[K KeyConstraint] struct{}
@@ -38,11 +66,17 @@ func (interhash[K]) Equal(i, j K) bool {
 }
 
 func NewSet[K KeyConstraint]() Settish[K] {
-	return mapToSet[K]{NewMap[K, struct{}]()}
+	return newMapToSet(NewMap[K, struct{}]())
 }
 
+// The returned set is a SortedSettish. NewSortedSettish returns it as one.
 func NewSortedSet[K KeyConstraint](lesser lessFunc[K]) Settish[K] {
-	return mapToSet[K]{NewSortedMap[K, struct{}](lesser)}
+	return NewSortedSettish(lesser)
+}
+
+// NewSortedSet, typed as a SortedSettish. The sets its methods return are SortedSettish too.
+func NewSortedSettish[K KeyConstraint](lesser lessFunc[K]) SortedSettish[K] {
+	return sortedSet[K]{newMapToSet(NewSortedMap[K, struct{}](lesser))}
 }
 
 func (s mapToSet[K]) Add(x K) Settish[K] {
@@ -65,8 +99,88 @@ func (s mapToSet[K]) Contains(x K) bool {
 }
 
 func (s mapToSet[K]) All() iter.Seq[K] {
+	return keys(s.m.All())
+}
+
+func (s mapToSet[K]) Union(other Settish[K]) Settish[K] {
+	return union[K](s, other)
+}
+
+func (s mapToSet[K]) Intersect(other Settish[K]) Settish[K] {
+	s.m = s.intersectMap(other)
+	return s
+}
+
+// Returns the elements of s that are in other, in a map of s's kind. Walks whichever set is
+// smaller.
+func (s mapToSet[K]) intersectMap(other Settish[K]) Mappish[K, struct{}] {
+	if other.Len() < s.Len() {
+		m := s.empty
+		for x := range other.All() {
+			if s.Contains(x) {
+				m = m.Set(x, struct{}{})
+			}
+		}
+		return m
+	}
+	m := s.m
+	for x := range s.All() {
+		if !other.Contains(x) {
+			m = m.Delete(x)
+		}
+	}
+	return m
+}
+
+func (s mapToSet[K]) Difference(other Settish[K]) Settish[K] {
+	return difference[K](s, other)
+}
+
+func (s mapToSet[K]) IsSubset(other Settish[K]) bool {
+	return isSubset[K](s, other)
+}
+
+// The set algebra is written against Settish, so that the sets it returns are the same kind as the
+// first set it's given.
+
+func union[K any](s, other Settish[K]) Settish[K] {
+	for x := range other.All() {
+		s = s.Add(x)
+	}
+	return s
+}
+
+func difference[K any](s, other Settish[K]) Settish[K] {
+	// Walk whichever set is smaller.
+	if other.Len() < s.Len() {
+		for x := range other.All() {
+			s = s.Delete(x)
+		}
+		return s
+	}
+	for x := range s.All() {
+		if other.Contains(x) {
+			s = s.Delete(x)
+		}
+	}
+	return s
+}
+
+func isSubset[K any](s, other Settish[K]) bool {
+	if s.Len() > other.Len() {
+		return false
+	}
+	for x := range s.All() {
+		if !other.Contains(x) {
+			return false
+		}
+	}
+	return true
+}
+
+func keys[K, V any](seq iter.Seq2[K, V]) iter.Seq[K] {
 	return func(yield func(K) bool) {
-		for k := range s.m.All() {
+		for k := range seq {
 			if !yield(k) {
 				return
 			}
@@ -74,6 +188,59 @@ func (s mapToSet[K]) All() iter.Seq[K] {
 	}
 }
 
+// A mapToSet over a SortedMap. It has its own Add and Delete so that what they return is still a
+// sortedSet.
+type sortedSet[K KeyConstraint] struct {
+	mapToSet[K]
+}
+
+var _ SortedSettish[int] = sortedSet[int]{}
+
+func (s sortedSet[K]) sortedMap() SortedMap[K, struct{}] {
+	return s.m.(SortedMap[K, struct{}])
+}
+
+func (s sortedSet[K]) Add(x K) Settish[K] {
+	s.m = s.m.Set(x, struct{}{})
+	return s
+}
+
+func (s sortedSet[K]) Delete(x K) Settish[K] {
+	s.m = s.m.Delete(x)
+	return s
+}
+
+func (s sortedSet[K]) Union(other Settish[K]) Settish[K] {
+	return union[K](s, other)
+}
+
+func (s sortedSet[K]) Intersect(other Settish[K]) Settish[K] {
+	s.m = s.intersectMap(other)
+	return s
+}
+
+func (s sortedSet[K]) Difference(other Settish[K]) Settish[K] {
+	return difference[K](s, other)
+}
+
+func (s sortedSet[K]) Min() (x K, ok bool) {
+	x, _, ok = s.sortedMap().Min()
+	return
+}
+
+func (s sortedSet[K]) Max() (x K, ok bool) {
+	x, _, ok = s.sortedMap().Max()
+	return
+}
+
+func (s sortedSet[K]) Seek(x K) iter.Seq[K] {
+	return keys(s.sortedMap().Seek(x))
+}
+
+func (s sortedSet[K]) Backward() iter.Seq[K] {
+	return keys(s.sortedMap().Backward())
+}
+
 type Map[K KeyConstraint, V any] struct {
 	*immutable.Map[K, V]
 }
@@ -92,6 +259,10 @@ func (m Map[K, V]) Set(key K, value V) Mappish[K, V] {
 	return m
 }
 
+func (m Map[K, V]) Update(key K, f func(V, bool) V) Mappish[K, V] {
+	return m.Set(key, f(m.Get(key)))
+}
+
 func (sm Map[K, V]) All() iter.Seq2[K, V] {
 	return func(yield func(K, V) bool) {
 		it := sm.Map.Iterator()
@@ -118,11 +289,49 @@ func (sm SortedMap[K, V]) Delete(key K) Mappish[K, V] {
 	return sm
 }
 
+func (sm SortedMap[K, V]) Update(key K, f func(V, bool) V) Mappish[K, V] {
+	return sm.Set(key, f(sm.Get(key)))
+}
+
 func (sm SortedMap[K, V]) All() iter.Seq2[K, V] {
-	return func(yield func(K, V) bool) {
+	return sortedMapSeq(sm.SortedMap.Iterator, (*immutable.SortedMapIterator[K, V]).Next)
+}
+
+func (sm SortedMap[K, V]) Min() (K, V, bool) {
+	return sm.SortedMap.Iterator().Next()
+}
+
+func (sm SortedMap[K, V]) Max() (K, V, bool) {
+	it := sm.SortedMap.Iterator()
+	it.Last()
+	return it.Next()
+}
+
+func (sm SortedMap[K, V]) Seek(key K) iter.Seq2[K, V] {
+	return sortedMapSeq(func() *immutable.SortedMapIterator[K, V] {
+		it := sm.SortedMap.Iterator()
+		it.Seek(key)
+		return it
+	}, (*immutable.SortedMapIterator[K, V]).Next)
+}
+
+func (sm SortedMap[K, V]) Backward() iter.Seq2[K, V] {
+	return sortedMapSeq(func() *immutable.SortedMapIterator[K, V] {
 		it := sm.SortedMap.Iterator()
+		it.Last()
+		return it
+	}, (*immutable.SortedMapIterator[K, V]).Prev)
+}
+
+// Iterates with a fresh iterator each time, stepping it with next.
+func sortedMapSeq[K KeyConstraint, V any](
+	start func() *immutable.SortedMapIterator[K, V],
+	next func(*immutable.SortedMapIterator[K, V]) (K, V, bool),
+) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		it := start()
 		for {
-			k, v, ok := it.Next()
+			k, v, ok := next(it)
 			if !ok || !yield(k, v) {
 				return
 			}
@@ -146,20 +355,51 @@ func (me comparer[K]) Compare(i, j K) int {
 	}
 }
 
+// The returned map is a SortedMappish. NewSortedMappish returns it as one.
 func NewSortedMap[K KeyConstraint, V any](less lessFunc[K]) Mappish[K, V] {
+	return NewSortedMappish[K, V](less)
+}
+
+// NewSortedMap, typed as a SortedMappish. The maps its methods return are SortedMappish too.
+func NewSortedMappish[K KeyConstraint, V any](less lessFunc[K]) SortedMappish[K, V] {
 	return SortedMap[K, V]{
 		SortedMap: immutable.NewSortedMap[K, V](comparer[K]{less}),
 	}
 }
 
+// A NewSortedMappish in the natural order of the keys.
+func NewOrderedMap[K cmp.Ordered, V any]() SortedMappish[K, V] {
+	return NewSortedMappish[K, V](cmp.Less[K])
+}
+
+// A NewSortedSettish in the natural order of the elements.
+func NewOrderedSet[K cmp.Ordered]() SortedSettish[K] {
+	return NewSortedSettish[K](cmp.Less[K])
+}
+
 type Mappish[K, V any] interface {
 	Set(K, V) Mappish[K, V]
 	Delete(key K) Mappish[K, V]
 	Get(key K) (V, bool)
+	// Sets the value for key to what f returns given the current one, and whether there was one.
+	Update(key K, f func(V, bool) V) Mappish[K, V]
 	All() iter.Seq2[K, V]
 	Len() int
 }
 
+// The Mappish returned by NewSortedMap implements this too.
+type SortedMappish[K, V any] interface {
+	Mappish[K, V]
+	Min() (K, V, bool)
+	Max() (K, V, bool)
+	// Iterates in order from the first key that isn't less than the one given.
+	Seek(K) iter.Seq2[K, V]
+	// Iterates in reverse order.
+	Backward() iter.Seq2[K, V]
+}
+
+var _ SortedMappish[int, int] = SortedMap[int, int]{}
+
 func GetLeft(l, _ any) any {
 	return l
 }
@@ -3,6 +3,8 @@ package stmutil
 import (
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -114,3 +116,161 @@ func TestSortedMapIntKeys(t *testing.T) {
 		}
 	})
 }
+
+func TestMapUpdate(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		incr := func(v int, ok bool) int {
+			if !ok {
+				return 1
+			}
+			return v + 1
+		}
+		m := NewMap[string, int]()
+		m = m.Update("a", incr)
+		m = m.Update("a", incr)
+		if v, _ := m.Get("a"); v != 2 {
+			t.Fatalf(`Get("a") = %v, want 2`, v)
+		}
+	})
+}
+
+func TestSortedMapNavigation(t *testing.T) {
+	m := NewSortedMap[int, string](func(l, r int) bool { return l < r })
+	for i := 10; i > 0; i-- {
+		m = m.Set(i*10, "value")
+	}
+	sm := m.(SortedMappish[int, string])
+	if k, _, _ := sm.Min(); k != 10 {
+		t.Fatalf("Min() = %v, want 10", k)
+	}
+	if k, _, _ := sm.Max(); k != 100 {
+		t.Fatalf("Max() = %v, want 100", k)
+	}
+	var got []int
+	for k := range sm.Seek(75) {
+		got = append(got, k)
+	}
+	if !slices.Equal(got, []int{80, 90, 100}) {
+		t.Fatalf("Seek(75) yielded %v", got)
+	}
+	got = got[:0]
+	for k := range sm.Backward() {
+		got = append(got, k)
+		if len(got) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(got, []int{100, 90, 80}) {
+		t.Fatalf("Backward() yielded %v", got)
+	}
+	empty := NewSortedMappish[int, string](func(l, r int) bool { return l < r })
+	if _, _, ok := empty.Max(); ok {
+		t.Fatal("Max() of an empty map returned a key")
+	}
+}
+
+func TestSortedSetStaysSorted(t *testing.T) {
+	less := func(l, r int) bool { return l < r }
+	s := NewSortedSet(less).Add(3).Add(1).Add(2)
+	other := NewSortedSet(less).Add(2).Add(5)
+	s = s.Union(other).Delete(1)
+	ss, ok := s.(SortedSettish[int])
+	if !ok {
+		t.Fatalf("%T is no longer a SortedSettish", s)
+	}
+	if x, _ := ss.Min(); x != 2 {
+		t.Fatalf("Min() = %v, want 2", x)
+	}
+	if got := slices.Collect(ss.Backward()); !slices.Equal(got, []int{5, 3, 2}) {
+		t.Fatalf("Backward() yielded %v", got)
+	}
+	if got := slices.Collect(ss.Seek(3)); !slices.Equal(got, []int{3, 5}) {
+		t.Fatalf("Seek(3) yielded %v", got)
+	}
+}
+
+// Intersecting with a smaller set builds the result from it, in the sorted set's order.
+func TestSortedSetIntersectSmaller(t *testing.T) {
+	greater := func(l, r int) bool { return l > r }
+	s := NewSortedSet(greater).Add(1).Add(2).Add(3).Add(4)
+	s = s.Intersect(NewSet[int]().Add(3).Add(1).Add(7))
+	ss, ok := s.(SortedSettish[int])
+	if !ok {
+		t.Fatalf("%T is no longer a SortedSettish", s)
+	}
+	if got := slices.Collect(ss.All()); !slices.Equal(got, []int{3, 1}) {
+		t.Fatalf("All() yielded %v", got)
+	}
+}
+
+// Compares strings ignoring case.
+type foldHasher struct{}
+
+func (foldHasher) Hash(s string) uint32   { return uint32(len(strings.ToLower(s))) }
+func (foldHasher) Equal(a, b string) bool { return strings.EqualFold(a, b) }
+
+// The result of Union and Intersect is the kind of set they're called on, whichever set is larger.
+func TestSetOpsKeepReceiverKind(t *testing.T) {
+	plain := NewSet[string]().Add("a")
+	folded := NewSetWithHasher[string](foldHasher{}).Add("A").Add("b")
+	u := plain.Union(folded)
+	if u.Len() != 3 || !u.Contains("a") || !u.Contains("A") {
+		t.Fatalf("Union compared elements ignoring case: %v", slices.Sorted(u.All()))
+	}
+	if u := folded.Union(plain); u.Len() != 2 {
+		t.Fatalf("Union compared elements by case: %v", slices.Sorted(u.All()))
+	}
+	sorted := NewOrderedSet[string]().Add("a").Add("b").Add("c")
+	if _, ok := plain.Union(sorted).(SortedSettish[string]); ok {
+		t.Fatal("Union of a plain set with a larger sorted one is sorted")
+	}
+	big := NewSet[string]().Add("a").Add("b").Add("c").Add("d")
+	i := big.Intersect(NewOrderedSet[string]().Add("b").Add("z"))
+	if _, ok := i.(SortedSettish[string]); ok {
+		t.Fatal("Intersect of a plain set with a smaller sorted one is sorted")
+	}
+	if got := slices.Collect(i.All()); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("Intersect = %v", got)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		set := func(xs ...int) Settish[int] {
+			s := NewSet[int]()
+			for _, x := range xs {
+				s = s.Add(x)
+			}
+			return s
+		}
+		sorted := func(s Settish[int]) []int {
+			return slices.Sorted(s.All())
+		}
+		a, b := set(1, 2, 3, 4), set(3, 4, 5)
+		if got := sorted(a.Union(b)); !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+			t.Errorf("Union = %v", got)
+		}
+		if got := sorted(a.Intersect(b)); !slices.Equal(got, []int{3, 4}) {
+			t.Errorf("Intersect = %v", got)
+		}
+		// The other way around, the smaller set is the receiver.
+		if got := sorted(b.Union(a)); !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+			t.Errorf("Union into a larger set = %v", got)
+		}
+		if got := sorted(b.Intersect(a)); !slices.Equal(got, []int{3, 4}) {
+			t.Errorf("Intersect with a larger set = %v", got)
+		}
+		if got := sorted(a.Difference(b)); !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("Difference = %v", got)
+		}
+		if got := sorted(b.Difference(set(5))); !slices.Equal(got, []int{3, 4}) {
+			t.Errorf("Difference with a smaller set = %v", got)
+		}
+		if got := sorted(a); !slices.Equal(got, []int{1, 2, 3, 4}) {
+			t.Errorf("the original set was modified: %v", got)
+		}
+		if !set(3, 4).IsSubset(a) || b.IsSubset(a) || a.IsSubset(set(1)) {
+			t.Error("IsSubset is wrong")
+		}
+	})
+}
@@ -2,6 +2,7 @@ package stmutil
 
 import (
 	"context"
+	"sync"
 
 	"github.com/anacrolix/stm"
 )
@@ -10,8 +11,8 @@ import (
 // called when the user is no longer interested in the var.
 func ContextDoneVar(ctx context.Context) (*stm.Var[bool], func()) {
 	if ctx.Err() != nil {
-		// TODO: What if we had read-only Vars? Then we could have a global one for this that we
-		// just reuse.
+		// ContextDone shares one constant for this. Callers of this get a Var, so they get their
+		// own.
 		return stm.NewBuiltinEqVar(true), func() {}
 	}
 	v := stm.NewVar(false)
@@ -24,3 +25,75 @@ func ContextDoneVar(ctx context.Context) (*stm.Var[bool], func()) {
 	})
 	return v, func() { stop() }
 }
+
+// ContextDoneVar for several Contexts: the var becomes true when any of them is done. The cancel
+// function unregisters from all of them.
+func AnyDoneVar(ctxs ...context.Context) (*stm.Var[bool], func()) {
+	for _, ctx := range ctxs {
+		if ctx.Err() != nil {
+			return stm.NewBuiltinEqVar(true), func() {}
+		}
+	}
+	v := stm.NewVar(false)
+	// Guards stops, as the first Context can be done before the rest are registered.
+	var mu sync.Mutex
+	stops := make([]func() bool, 0, len(ctxs))
+	cancel := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, ctx := range ctxs {
+		stops = append(stops, context.AfterFunc(ctx, func() {
+			stm.AtomicSet(v, true)
+			// The others have nothing left to say.
+			cancel()
+		}))
+	}
+	return v, cancel
+}
+
+// Returns an STM var that contains nil until ctx is done, and then the cause of it being done, per
+// context.Cause. The cancel function is as for ContextDoneVar.
+func ContextErrVar(ctx context.Context) (*stm.Var[error], func()) {
+	if ctx.Err() != nil {
+		return stm.NewVar(context.Cause(ctx)), func() {}
+	}
+	v := stm.NewVar[error](nil)
+	stop := context.AfterFunc(ctx, func() {
+		stm.AtomicSet(v, context.Cause(ctx))
+	})
+	return v, func() { stop() }
+}
+
+var alreadyDone = stm.Const(true)
+
+// ContextDoneVar for callers that only read the Var. A Context that's already done gets a shared
+// constant, which costs nothing to create and nothing to read.
+func ContextDone(ctx context.Context) (stm.ReadOnlyVar[bool], func()) {
+	if ctx.Err() != nil {
+		return alreadyDone, func() {}
+	}
+	return ContextDoneVar(ctx)
+}
+
+// ContextWhen returns a Context derived from parent that's also cancelled once op returns true. op
+// is run as by stm.Atomically, and again whenever a Var it read changes, until the Context is done.
+// If op panics when it's run again, the Context is cancelled with the *stm.PanicError as the cause.
+func ContextWhen(parent context.Context, op stm.Operation[bool]) (context.Context, context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(parent)
+	cancel := func() { cancelCause(nil) }
+	// The wait is registered with the Vars op reads, and with the Context, so nothing waits on
+	// either of them in a goroutine.
+	stopWaiting := stm.OnReady(stm.VoidOperation(func(tx *stm.Tx) {
+		tx.Assert(op(tx))
+	}), func(_ struct{}, err error) {
+		cancelCause(err)
+	})
+	context.AfterFunc(ctx, stopWaiting)
+	return ctx, cancel
+}
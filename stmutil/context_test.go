@@ -2,6 +2,8 @@ package stmutil
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -72,3 +74,121 @@ func TestContextDoneVarCancelUnregisters(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	qt.Check(t, qt.IsFalse(stm.AtomicGet(v)))
 }
+
+func TestContextDoneForContextAlreadyDone(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	v, cancel := ContextDone(ctx)
+	defer cancel()
+	qt.Check(t, qt.Equals(v, alreadyDone))
+	qt.Check(t, qt.IsTrue(stm.Atomically(v.Get)))
+}
+
+func TestContextWhen(t *testing.T) {
+	shutdown := stm.NewVar(false)
+	ctx, cancel := ContextWhen(context.Background(), func(tx *stm.Tx) bool {
+		return shutdown.Get(tx)
+	})
+	defer cancel()
+	qt.Check(t, qt.IsNil(ctx.Err()))
+	stm.AtomicSet(shutdown, true)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't cancelled")
+	}
+	qt.Check(t, qt.ErrorIs(ctx.Err(), context.Canceled))
+}
+
+func TestContextWhenAlreadyTrue(t *testing.T) {
+	ctx, cancel := ContextWhen(context.Background(), func(*stm.Tx) bool {
+		return true
+	})
+	defer cancel()
+	qt.Check(t, qt.IsNotNil(ctx.Err()))
+}
+
+// The condition isn't watched once the Context is done for other reasons.
+func TestContextWhenParentDone(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	shutdown := stm.NewVar(false)
+	var runs atomic.Int32
+	ctx, cancel := ContextWhen(parent, func(tx *stm.Tx) bool {
+		runs.Add(1)
+		return shutdown.Get(tx)
+	})
+	defer cancel()
+	cancelParent()
+	<-ctx.Done()
+	// The condition stops being watched soon after, rather than with the Context being done.
+	time.Sleep(10 * time.Millisecond)
+	stm.AtomicSet(shutdown, true)
+	time.Sleep(10 * time.Millisecond)
+	qt.Check(t, qt.Equals(runs.Load(), 1))
+}
+
+func TestAnyDoneVar(t *testing.T) {
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	v, cancel := AnyDoneVar(context.Background(), ctx1, ctx2)
+	defer cancel()
+	qt.Check(t, qt.IsFalse(stm.AtomicGet(v)))
+	cancel2()
+	qt.Check(t, qt.IsTrue(awaitDoneVar(v, 2*time.Second)))
+}
+
+func TestAnyDoneVarAlreadyDone(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	v, cancel := AnyDoneVar(context.Background(), ctx)
+	defer cancel()
+	qt.Check(t, qt.IsTrue(stm.AtomicGet(v)))
+}
+
+func TestAnyDoneVarCancel(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	v, cancel := AnyDoneVar(ctx, context.Background())
+	cancel()
+	cancelCtx()
+	time.Sleep(10 * time.Millisecond)
+	qt.Check(t, qt.IsFalse(stm.AtomicGet(v)))
+}
+
+func TestContextErrVar(t *testing.T) {
+	cause := errors.New("shutting down")
+	ctx, cancelCtx := context.WithCancelCause(context.Background())
+	v, cancel := ContextErrVar(ctx)
+	defer cancel()
+	qt.Check(t, qt.IsNil(stm.AtomicGet(v)))
+	cancelCtx(cause)
+	err := stm.Atomically(func(tx *stm.Tx) error {
+		err := v.Get(tx)
+		tx.Assert(err != nil)
+		return err
+	})
+	qt.Check(t, qt.Equals(err, cause))
+	// Already done.
+	v, cancel = ContextErrVar(ctx)
+	defer cancel()
+	qt.Check(t, qt.Equals(stm.AtomicGet(v), cause))
+}
+
+func TestContextWhenPanic(t *testing.T) {
+	x := stm.NewVar(0)
+	ctx, cancel := ContextWhen(context.Background(), func(tx *stm.Tx) bool {
+		if x.Get(tx) > 0 {
+			panic("condition")
+		}
+		return false
+	})
+	defer cancel()
+	stm.AtomicSet(x, 1)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't cancelled")
+	}
+	var pe *stm.PanicError
+	qt.Check(t, qt.ErrorAs(context.Cause(ctx), &pe))
+}
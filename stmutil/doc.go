@@ -0,0 +1,10 @@
+/*
+Package stmutil provides containers and helpers built on the stm package.
+
+The persistent containers, like those from NewMap and NewSortedMap, are values,
+and are shared between transactions by holding them in a Var. A container held
+in a single Var is invalidated by a write to any of its keys. TMap, TSortedMap
+and MapVar spread a container over more than one Var, or read it through
+projections, so that transactions only conflict over the keys they use.
+*/
+package stmutil
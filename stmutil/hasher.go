@@ -0,0 +1,130 @@
+package stmutil
+
+import (
+	"bytes"
+	"hash/maphash"
+	"iter"
+
+	"github.com/benbjohnson/immutable"
+)
+
+// Hashes and compares keys for NewMapWithHasher and NewSetWithHasher. Keys that are Equal must have
+// the same Hash. This is the same shape as immutable.Hasher, but keys needn't be comparable.
+type Hasher[K any] interface {
+	Hash(K) uint32
+	Equal(K, K) bool
+}
+
+// A Hasher for byte slice keys, which compares them by content.
+type BytesHasher struct{}
+
+func (BytesHasher) Hash(b []byte) uint32 {
+	return uint32(maphash.Bytes(hashSeed, b))
+}
+
+func (BytesHasher) Equal(a, b []byte) bool {
+	return bytes.Equal(a, b)
+}
+
+// Returns a map that hashes and compares keys with hasher instead of ==, so the keys needn't be
+// comparable, and comparable ones can be matched by something other than identity.
+func NewMapWithHasher[K, V any](hasher Hasher[K]) Mappish[K, V] {
+	return hasherMap[K, V]{
+		buckets: immutable.NewMap[uint32, []hasherMapEntry[K, V]](interhash[uint32]{}),
+		hasher:  hasher,
+	}
+}
+
+// The set counterpart of NewMapWithHasher.
+func NewSetWithHasher[K any](hasher Hasher[K]) Settish[K] {
+	return newMapToSet(NewMapWithHasher[K, struct{}](hasher))
+}
+
+// immutable.Map needs comparable keys, so this keys it by hash instead, and keeps the entries whose
+// keys share a hash in a bucket. Buckets are replaced rather than modified, as they're shared
+// between versions of the map.
+type hasherMap[K, V any] struct {
+	buckets *immutable.Map[uint32, []hasherMapEntry[K, V]]
+	hasher  Hasher[K]
+	len     int
+}
+
+type hasherMapEntry[K, V any] struct {
+	key   K
+	value V
+}
+
+func (m hasherMap[K, V]) find(key K) (hash uint32, bucket []hasherMapEntry[K, V], index int) {
+	hash = m.hasher.Hash(key)
+	bucket, _ = m.buckets.Get(hash)
+	for i, e := range bucket {
+		if m.hasher.Equal(e.key, key) {
+			return hash, bucket, i
+		}
+	}
+	return hash, bucket, -1
+}
+
+func (m hasherMap[K, V]) Get(key K) (_ V, ok bool) {
+	_, bucket, i := m.find(key)
+	if i < 0 {
+		return
+	}
+	return bucket[i].value, true
+}
+
+func (m hasherMap[K, V]) Set(key K, value V) Mappish[K, V] {
+	hash, bucket, i := m.find(key)
+	newBucket := make([]hasherMapEntry[K, V], len(bucket), len(bucket)+1)
+	copy(newBucket, bucket)
+	if i < 0 {
+		newBucket = append(newBucket, hasherMapEntry[K, V]{key, value})
+		m.len++
+	} else {
+		newBucket[i].value = value
+	}
+	m.buckets = m.buckets.Set(hash, newBucket)
+	return m
+}
+
+func (m hasherMap[K, V]) Delete(key K) Mappish[K, V] {
+	hash, bucket, i := m.find(key)
+	if i < 0 {
+		return m
+	}
+	if len(bucket) == 1 {
+		m.buckets = m.buckets.Delete(hash)
+	} else {
+		newBucket := make([]hasherMapEntry[K, V], 0, len(bucket)-1)
+		newBucket = append(newBucket, bucket[:i]...)
+		newBucket = append(newBucket, bucket[i+1:]...)
+		m.buckets = m.buckets.Set(hash, newBucket)
+	}
+	m.len--
+	return m
+}
+
+func (m hasherMap[K, V]) Update(key K, f func(V, bool) V) Mappish[K, V] {
+	return m.Set(key, f(m.Get(key)))
+}
+
+func (m hasherMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		it := m.buckets.Iterator()
+		for {
+			_, bucket, ok := it.Next()
+			if !ok {
+				return
+			}
+			for _, e := range bucket {
+				if !yield(e.key, e.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m hasherMap[K, V]) Len() int {
+	return m.len
+}
@@ -0,0 +1,103 @@
+package stmutil
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMapWithHasherByteSliceKeys(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewMapWithHasher[[]byte, int](BytesHasher{})
+		for i := range 100 {
+			m = m.Set([]byte(strings.Repeat("a", i)), i)
+		}
+		// A different slice with the same contents finds the same entry.
+		m = m.Set([]byte("aaa"), -3)
+		if m.Len() != 100 {
+			t.Fatalf("got %v keys, want 100", m.Len())
+		}
+		if v, ok := m.Get([]byte("aaa")); !ok || v != -3 {
+			t.Fatalf(`Get("aaa") = %v, %v`, v, ok)
+		}
+		m = m.Delete([]byte("aa"))
+		if _, ok := m.Get([]byte("aa")); ok || m.Len() != 99 {
+			t.Fatal("deleted key is still present")
+		}
+		n := 0
+		for range m.All() {
+			n++
+		}
+		if n != 99 {
+			t.Fatalf("All yielded %v entries, want 99", n)
+		}
+	})
+}
+
+// Every key collides, so every entry lives in the one bucket.
+type collidingHasher struct{}
+
+func (collidingHasher) Hash(string) uint32     { return 0 }
+func (collidingHasher) Equal(a, b string) bool { return a == b }
+
+func TestMapWithHasherCollisions(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewMapWithHasher[string, int](collidingHasher{})
+		m = m.Set("a", 1).Set("b", 2).Set("c", 3)
+		before := m
+		m = m.Delete("b").Set("a", 10)
+		if v, _ := before.Get("a"); v != 1 {
+			t.Fatalf("earlier version changed: Get(\"a\") = %v", v)
+		}
+		if _, ok := before.Get("b"); !ok {
+			t.Fatal("earlier version lost a key")
+		}
+		var keys []string
+		for k := range m.All() {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, []string{"a", "c"}) {
+			t.Fatalf("got keys %v", keys)
+		}
+	})
+}
+
+// Pointer keys that hash by what they point to, instead of by identity.
+func TestSetWithHasherStructuralEquality(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		type point struct{ x, y int }
+		s := NewSetWithHasher[*point](pointHasher[point]{})
+		s = s.Add(&point{1, 2})
+		if !s.Contains(&point{1, 2}) {
+			t.Fatal("an equal point wasn't found")
+		}
+		if s.Add(&point{1, 2}).Len() != 1 {
+			t.Fatal("adding an equal point added another element")
+		}
+	})
+}
+
+type pointHasher[T comparable] struct{}
+
+func (pointHasher[T]) Hash(p *T) uint32 {
+	return interhash[T]{}.Hash(*p)
+}
+
+func (pointHasher[T]) Equal(a, b *T) bool {
+	return *a == *b
+}
+
+func TestOrderedMap(t *testing.T) {
+	m := NewOrderedMap[string, int]().Set("b", 2).Set("a", 1).Set("c", 3)
+	var keys []string
+	for k := range m.All() {
+		keys = append(keys, k)
+	}
+	if !slices.Equal(keys, []string{"a", "b", "c"}) {
+		t.Fatalf("got keys in order %v", keys)
+	}
+	if x, _ := NewOrderedSet[float64]().Add(2.5).Add(-1).(SortedSettish[float64]).Min(); x != -1 {
+		t.Fatalf("Min() = %v, want -1", x)
+	}
+}
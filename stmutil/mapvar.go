@@ -0,0 +1,72 @@
+package stmutil
+
+import (
+	"github.com/anacrolix/stm"
+)
+
+// A map in a Var, where a transaction that reads a single key with GetKey is only invalidated, or
+// woken from Retry, by writes to that key. Reading the whole map with Get depends on every key, as
+// it would for a Mappish in a plain Var.
+type MapVar[K KeyConstraint, V any] struct {
+	all *stm.Var[mapVarState[K, V]]
+}
+
+type mapVarState[K KeyConstraint, V any] struct {
+	values Mappish[K, V]
+	// The write that last set each key, for GetKey to tell writes to a key apart without comparing
+	// values. Numbered from lastStamp, so a key that's deleted and set again doesn't get back a
+	// stamp a transaction read for it before.
+	stamps    Mappish[K, uint64]
+	lastStamp uint64
+}
+
+// What GetKey reads of a key. A missing key has a zero stamp.
+type mapVarEntry[V any] struct {
+	value V
+	ok    bool
+	stamp uint64
+}
+
+func NewMapVar[K KeyConstraint, V any]() *MapVar[K, V] {
+	return &MapVar[K, V]{
+		all: stm.NewVar(mapVarState[K, V]{
+			values: NewMap[K, V](),
+			stamps: NewMap[K, uint64](),
+		}),
+	}
+}
+
+// Returns the whole map. The transaction then depends on every key in it.
+func (m *MapVar[K, V]) Get(tx *stm.Tx) Mappish[K, V] {
+	return m.all.Get(tx).values
+}
+
+// Returns the value for key, depending only on that key.
+func (m *MapVar[K, V]) GetKey(tx *stm.Tx, key K) (V, bool) {
+	e := stm.GetFuncEq(tx, m.all, func(s mapVarState[K, V]) mapVarEntry[V] {
+		value, ok := s.values.Get(key)
+		stamp, _ := s.stamps.Get(key)
+		return mapVarEntry[V]{value, ok, stamp}
+	}, func(a, b mapVarEntry[V]) bool {
+		return a.stamp == b.stamp
+	})
+	return e.value, e.ok
+}
+
+func (m *MapVar[K, V]) Set(tx *stm.Tx, key K, value V) {
+	s := m.all.Get(tx)
+	s.lastStamp++
+	s.values = s.values.Set(key, value)
+	s.stamps = s.stamps.Set(key, s.lastStamp)
+	m.all.Set(tx, s)
+}
+
+func (m *MapVar[K, V]) Delete(tx *stm.Tx, key K) {
+	s := m.all.Get(tx)
+	if _, ok := s.values.Get(key); !ok {
+		return
+	}
+	s.values = s.values.Delete(key)
+	s.stamps = s.stamps.Delete(key)
+	m.all.Set(tx, s)
+}
@@ -0,0 +1,93 @@
+package stmutil
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm"
+	qt "github.com/go-quicktest/qt"
+)
+
+// A transaction waiting on one key isn't rerun by writes to other keys.
+func TestMapVarGetKeyOnlyWokenByItsKey(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewMapVar[string, int]()
+		var attempts atomic.Int32
+		done := make(chan int)
+		go func() {
+			done <- stm.Atomically(func(tx *stm.Tx) int {
+				attempts.Add(1)
+				v, _ := m.GetKey(tx, "watched")
+				tx.Assert(v > 0)
+				return v
+			})
+		}()
+		time.Sleep(10 * time.Millisecond)
+		for i := range 10 {
+			stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+				m.Set(tx, "other", i)
+			}))
+		}
+		time.Sleep(10 * time.Millisecond)
+		qt.Check(t, qt.Equals(attempts.Load(), 1))
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			m.Set(tx, "watched", 7)
+		}))
+		select {
+		case v := <-done:
+			qt.Check(t, qt.Equals(v, 7))
+		case <-time.After(time.Second):
+			t.Fatal("waiter was not woken by a write to its key")
+		}
+		qt.Check(t, qt.Equals(attempts.Load(), 2))
+	})
+}
+
+func TestMapVarGetAndDelete(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewMapVar[string, int]()
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			m.Set(tx, "a", 1)
+			m.Set(tx, "b", 2)
+			m.Delete(tx, "a")
+			m.Delete(tx, "c")
+		}))
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			_, ok := m.GetKey(tx, "a")
+			qt.Check(t, qt.IsFalse(ok))
+			v, ok := m.GetKey(tx, "b")
+			qt.Check(t, qt.IsTrue(ok))
+			qt.Check(t, qt.Equals(v, 2))
+			qt.Check(t, qt.Equals(m.Get(tx).Len(), 1))
+		}))
+	})
+}
+
+// Deleting a key and setting it again to the same value is still a write to it.
+func TestMapVarGetKeyDeletedAndSetAgain(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewMapVar[string, int]()
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			m.Set(tx, "a", 1)
+		}))
+		attempts := 0
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			attempts++
+			m.GetKey(tx, "missing")
+			v, _ := m.GetKey(tx, "a")
+			if attempts == 1 {
+				stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+					m.Delete(tx, "a")
+				}))
+				stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+					m.Set(tx, "a", v)
+				}))
+			}
+		}))
+		qt.Check(t, qt.Equals(attempts, 2))
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			qt.Check(t, qt.Equals(m.Get(tx).Len(), 1))
+		}))
+	})
+}
@@ -0,0 +1,127 @@
+package stmutil
+
+import (
+	"github.com/anacrolix/stm"
+	"github.com/benbjohnson/immutable"
+)
+
+// A transactional priority queue, ordered by a user-supplied less function. Values that compare
+// equal come out in the order they were pushed. The queue is a persistent sorted map held in a
+// Var, so popping the least value and updating other state can happen in the same transaction.
+type PriorityQueue[T any] struct {
+	state *stm.Var[priorityQueueState[T]]
+}
+
+// Identifies a value pushed onto a PriorityQueue, so that it can be removed or reprioritized later.
+// A handle is only good for the queue that returned it.
+type PriorityQueueHandle[T any] struct {
+	// The current entry for the value, or nil once it's no longer in the queue.
+	item *stm.Var[*priorityQueueItem[T]]
+}
+
+type priorityQueueItem[T any] struct {
+	value T
+	// Breaks ties between values that compare equal.
+	seq uint64
+}
+
+type priorityQueueState[T any] struct {
+	items   *immutable.SortedMap[*priorityQueueItem[T], *PriorityQueueHandle[T]]
+	nextSeq uint64
+}
+
+type priorityQueueComparer[T any] struct {
+	less func(l, r T) bool
+}
+
+func (me priorityQueueComparer[T]) Compare(i, j *priorityQueueItem[T]) int {
+	if me.less(i.value, j.value) {
+		return -1
+	} else if me.less(j.value, i.value) {
+		return 1
+	}
+	if i.seq < j.seq {
+		return -1
+	} else if i.seq > j.seq {
+		return 1
+	}
+	return 0
+}
+
+func NewPriorityQueue[T any](less func(l, r T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{
+		state: stm.NewVar(priorityQueueState[T]{
+			items: immutable.NewSortedMap[*priorityQueueItem[T], *PriorityQueueHandle[T]](
+				priorityQueueComparer[T]{less}),
+		}),
+	}
+}
+
+func (q *PriorityQueue[T]) Push(tx *stm.Tx, value T) *PriorityQueueHandle[T] {
+	state := q.state.Get(tx)
+	item := &priorityQueueItem[T]{value: value, seq: state.nextSeq}
+	h := &PriorityQueueHandle[T]{item: stm.NewVar(item)}
+	state.items = state.items.Set(item, h)
+	state.nextSeq++
+	q.state.Set(tx, state)
+	return h
+}
+
+func (q *PriorityQueue[T]) min(tx *stm.Tx) (item *priorityQueueItem[T], h *PriorityQueueHandle[T], ok bool) {
+	return q.state.Get(tx).items.Iterator().Next()
+}
+
+// Removes and returns the least value, retrying if the queue is empty.
+func (q *PriorityQueue[T]) Pop(tx *stm.Tx) T {
+	item, h, ok := q.min(tx)
+	if !ok {
+		tx.Retry()
+	}
+	q.remove(tx, item, h)
+	return item.value
+}
+
+// Returns the least value without removing it. ok is false if the queue is empty.
+func (q *PriorityQueue[T]) PeekMin(tx *stm.Tx) (_ T, ok bool) {
+	item, _, ok := q.min(tx)
+	if !ok {
+		return
+	}
+	return item.value, true
+}
+
+func (q *PriorityQueue[T]) Len(tx *stm.Tx) int {
+	return q.state.Get(tx).items.Len()
+}
+
+func (q *PriorityQueue[T]) remove(tx *stm.Tx, item *priorityQueueItem[T], h *PriorityQueueHandle[T]) {
+	state := q.state.Get(tx)
+	state.items = state.items.Delete(item)
+	q.state.Set(tx, state)
+	h.item.Set(tx, nil)
+}
+
+// Removes the value for the handle. Returns false if it was no longer in the queue.
+func (q *PriorityQueue[T]) Remove(tx *stm.Tx, h *PriorityQueueHandle[T]) bool {
+	item := h.item.Get(tx)
+	if item == nil {
+		return false
+	}
+	q.remove(tx, item, h)
+	return true
+}
+
+// Replaces the value for the handle, which moves it to where the new value belongs. It keeps its
+// place relative to values that compare equal. Returns false if it was no longer in the queue.
+func (q *PriorityQueue[T]) Update(tx *stm.Tx, h *PriorityQueueHandle[T], value T) bool {
+	old := h.item.Get(tx)
+	if old == nil {
+		return false
+	}
+	item := &priorityQueueItem[T]{value: value, seq: old.seq}
+	state := q.state.Get(tx)
+	state.items = state.items.Delete(old).Set(item, h)
+	q.state.Set(tx, state)
+	h.item.Set(tx, item)
+	return true
+}
@@ -0,0 +1,110 @@
+package stmutil
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm"
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestPriorityQueueRemoveAndUpdate(t *testing.T) {
+	q := NewPriorityQueue(intLess)
+	var handles []*PriorityQueueHandle[int]
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		handles = handles[:0]
+		for _, v := range []int{5, 3, 8, 1} {
+			handles = append(handles, q.Push(tx, v))
+		}
+	}))
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		qt.Check(t, qt.IsTrue(q.Remove(tx, handles[3])))
+		qt.Check(t, qt.IsFalse(q.Remove(tx, handles[3])))
+		qt.Check(t, qt.IsTrue(q.Update(tx, handles[2], 0)))
+		v, ok := q.PeekMin(tx)
+		qt.Check(t, qt.IsTrue(ok))
+		qt.Check(t, qt.Equals(v, 0))
+		qt.Check(t, qt.Equals(q.Len(tx), 3))
+	}))
+	var popped []int
+	for range 3 {
+		popped = append(popped, stm.Atomically(q.Pop))
+	}
+	qt.Check(t, qt.DeepEquals(popped, []int{0, 3, 5}))
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		_, ok := q.PeekMin(tx)
+		qt.Check(t, qt.IsFalse(ok))
+		qt.Check(t, qt.IsFalse(q.Update(tx, handles[0], 1)))
+	}))
+}
+
+func TestPriorityQueueEqualValuesAreFIFO(t *testing.T) {
+	type job struct{ pri, id int }
+	q := NewPriorityQueue(func(l, r job) bool { return l.pri < r.pri })
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		for i := range 5 {
+			q.Push(tx, job{pri: 1, id: i})
+		}
+	}))
+	for i := range 5 {
+		qt.Check(t, qt.Equals(stm.Atomically(q.Pop).id, i))
+	}
+}
+
+func TestPriorityQueuePopWaitsForPush(t *testing.T) {
+	q := NewPriorityQueue(intLess)
+	popped := make(chan int)
+	go func() {
+		popped <- stm.Atomically(q.Pop)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		q.Push(tx, 42)
+	}))
+	select {
+	case v := <-popped:
+		qt.Check(t, qt.Equals(v, 42))
+	case <-time.After(time.Second):
+		t.Fatal("Pop was not woken by Push")
+	}
+}
+
+// Consumers pop concurrently, numbering each pop in the same transaction, so
+// the pops can be put back in the order they took effect. That order must be
+// sorted.
+func TestPriorityQueueConcurrentOrdering(t *testing.T) {
+	const n = 1000
+	q := NewPriorityQueue(intLess)
+	var producers sync.WaitGroup
+	for range 10 {
+		producers.Go(func() {
+			for range n / 10 {
+				v := rand.IntN(n)
+				stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+					q.Push(tx, v)
+				}))
+			}
+		})
+	}
+	producers.Wait()
+	popCount := stm.NewVar(0)
+	popped := make([]int, n)
+	var consumers sync.WaitGroup
+	for range 10 {
+		consumers.Go(func() {
+			for range n / 10 {
+				r := stm.Atomically(func(tx *stm.Tx) [2]int {
+					i := popCount.Get(tx)
+					popCount.Set(tx, i+1)
+					return [2]int{i, q.Pop(tx)}
+				})
+				popped[r[0]] = r[1]
+			}
+		})
+	}
+	consumers.Wait()
+	qt.Check(t, qt.IsTrue(slices.IsSorted(popped)))
+}
@@ -0,0 +1,76 @@
+package stmutil
+
+import (
+	"github.com/anacrolix/stm"
+	"github.com/benbjohnson/immutable"
+)
+
+// A transactional FIFO queue, optionally bounded. Like a channel, it can be closed, after which the
+// values in it can still be popped.
+type Queue[T any] struct {
+	state    *stm.Var[queueState[T]]
+	capacity int
+}
+
+type queueState[T any] struct {
+	items  *immutable.List[T]
+	closed bool
+}
+
+// Returns a queue that holds at most capacity values, or any number if capacity is 0.
+func NewQueue[T any](capacity int) *Queue[T] {
+	return &Queue[T]{
+		state:    stm.NewVar(queueState[T]{items: immutable.NewList[T]()}),
+		capacity: capacity,
+	}
+}
+
+// Push adds v to the back of the queue, retrying while it's full. It panics if the queue is closed.
+func (q *Queue[T]) Push(tx *stm.Tx, v T) {
+	s := q.state.Get(tx)
+	if s.closed {
+		panic("push to closed queue")
+	}
+	tx.Assert(q.capacity == 0 || s.items.Len() < q.capacity)
+	s.items = s.items.Append(v)
+	q.state.Set(tx, s)
+}
+
+// Pop removes the value at the front of the queue, retrying while it's empty. ok is false if the
+// queue is closed and empty.
+func (q *Queue[T]) Pop(tx *stm.Tx) (v T, ok bool) {
+	s := q.state.Get(tx)
+	if s.items.Len() == 0 {
+		if s.closed {
+			return
+		}
+		tx.Retry()
+	}
+	v = s.items.Get(0)
+	s.items = s.items.Slice(1, s.items.Len())
+	q.state.Set(tx, s)
+	return v, true
+}
+
+// Puts v back at the front of the queue, regardless of its capacity, for a popped value that
+// couldn't be delivered.
+func (q *Queue[T]) unpop(tx *stm.Tx, v T) {
+	s := q.state.Get(tx)
+	s.items = s.items.Prepend(v)
+	q.state.Set(tx, s)
+}
+
+// Close marks the queue as having no more values to come.
+func (q *Queue[T]) Close(tx *stm.Tx) {
+	s := q.state.Get(tx)
+	s.closed = true
+	q.state.Set(tx, s)
+}
+
+func (q *Queue[T]) Closed(tx *stm.Tx) bool {
+	return q.state.Get(tx).closed
+}
+
+func (q *Queue[T]) Len(tx *stm.Tx) int {
+	return q.state.Get(tx).items.Len()
+}
@@ -0,0 +1,47 @@
+package stmutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm"
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue[int](2)
+	pop := func(tx *stm.Tx) [2]any {
+		v, ok := q.Pop(tx)
+		return [2]any{v, ok}
+	}
+	push := func(v int) func(*stm.Tx) {
+		return func(tx *stm.Tx) { q.Push(tx, v) }
+	}
+	qt.Check(t, qt.IsTrue(stm.WouldBlock(pop)))
+	stm.Atomically(stm.VoidOperation(push(1)))
+	stm.Atomically(stm.VoidOperation(push(2)))
+	qt.Check(t, qt.IsTrue(stm.WouldBlock(stm.VoidOperation(push(3)))))
+	qt.Check(t, qt.Equals(stm.Atomically(q.Len), 2))
+	stm.Atomically(stm.VoidOperation(q.Close))
+	qt.Check(t, qt.Equals(stm.Atomically(pop), [2]any{1, true}))
+	qt.Check(t, qt.Equals(stm.Atomically(pop), [2]any{2, true}))
+	qt.Check(t, qt.Equals(stm.Atomically(pop), [2]any{0, false}))
+	qt.Check(t, qt.PanicMatches(func() {
+		stm.Atomically(stm.VoidOperation(push(4)))
+	}, "push to closed queue"))
+}
+
+func TestQueuePopWaitsForPush(t *testing.T) {
+	q := NewQueue[int](0)
+	go func() {
+		time.Sleep(time.Millisecond)
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			q.Push(tx, 1)
+		}))
+	}()
+	v := stm.Atomically(func(tx *stm.Tx) int {
+		v, _ := q.Pop(tx)
+		return v
+	})
+	qt.Check(t, qt.Equals(v, 1))
+}
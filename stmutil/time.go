@@ -0,0 +1,26 @@
+package stmutil
+
+import (
+	"time"
+
+	"github.com/anacrolix/stm"
+)
+
+// Returns an STM var that becomes true once d has passed, and a cancel function to be called when
+// the user is no longer interested in the var.
+func TimeoutVar(d time.Duration) (*stm.Var[bool], func()) {
+	if d <= 0 {
+		return stm.NewBuiltinEqVar(true), func() {}
+	}
+	v := stm.NewVar(false)
+	// Like ContextDoneVar, the timer is all there is to stop, and no goroutine waits on it.
+	t := time.AfterFunc(d, func() {
+		stm.AtomicSet(v, true)
+	})
+	return v, func() { t.Stop() }
+}
+
+// TimeoutVar for a point in time rather than a duration from now.
+func DeadlineVar(t time.Time) (*stm.Var[bool], func()) {
+	return TimeoutVar(time.Until(t))
+}
@@ -0,0 +1,51 @@
+package stmutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/stm"
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestTimeoutVar(t *testing.T) {
+	v, cancel := TimeoutVar(10 * time.Millisecond)
+	defer cancel()
+	qt.Check(t, qt.IsFalse(stm.AtomicGet(v)))
+	qt.Check(t, qt.IsTrue(awaitDoneVar(v, 2*time.Second)))
+}
+
+func TestDeadlineVarAlreadyPassed(t *testing.T) {
+	v, cancel := DeadlineVar(time.Now().Add(-time.Second))
+	defer cancel()
+	qt.Check(t, qt.IsTrue(stm.AtomicGet(v)))
+}
+
+func TestTimeoutVarCancel(t *testing.T) {
+	v, cancel := TimeoutVar(10 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	qt.Check(t, qt.IsFalse(stm.AtomicGet(v)))
+}
+
+// Wait for a job, or give up after a timeout.
+func TestTimeoutVarSelect(t *testing.T) {
+	jobs := stm.NewVar[[]int](nil)
+	timedOut, cancel := TimeoutVar(10 * time.Millisecond)
+	defer cancel()
+	next := stm.Select(
+		func(tx *stm.Tx) int {
+			js := jobs.Get(tx)
+			tx.Assert(len(js) > 0)
+			jobs.Set(tx, js[1:])
+			return js[0]
+		},
+		func(tx *stm.Tx) int {
+			tx.Assert(timedOut.Get(tx))
+			return -1
+		},
+	)
+	stm.AtomicSet(jobs, []int{1})
+	qt.Check(t, qt.Equals(stm.Atomically(next), 1))
+	qt.Check(t, qt.Equals(stm.Atomically(next), -1))
+}
@@ -0,0 +1,103 @@
+package stmutil
+
+import (
+	"hash/maphash"
+	"iter"
+
+	"github.com/anacrolix/stm"
+)
+
+// The number of stripes a TMap gets from NewTMap.
+const defaultTMapStripes = 64
+
+// A transactional hash map. Keys are spread by hash over a fixed number of stripes, each with its
+// own Var, so transactions that touch keys in different stripes never conflict.
+type TMap[K KeyConstraint, V any] struct {
+	stripes []tmapStripe[K, V]
+}
+
+type tmapStripe[K KeyConstraint, V any] struct {
+	m *stm.Var[Mappish[K, V]]
+	// Kept apart from m so that Len doesn't conflict with writes that only replace values.
+	len *stm.Var[int]
+}
+
+func NewTMap[K KeyConstraint, V any]() *TMap[K, V] {
+	return NewTMapStripes[K, V](defaultTMapStripes)
+}
+
+// Returns a TMap with the given number of stripes. More stripes make conflicts between unrelated
+// keys rarer, at the cost of Len and All reading more Vars.
+func NewTMapStripes[K KeyConstraint, V any](stripes int) *TMap[K, V] {
+	if stripes < 1 {
+		panic("TMap needs at least one stripe")
+	}
+	m := &TMap[K, V]{
+		stripes: make([]tmapStripe[K, V], stripes),
+	}
+	for i := range m.stripes {
+		m.stripes[i] = tmapStripe[K, V]{
+			m:   stm.NewVar(NewMap[K, V]()),
+			len: stm.NewBuiltinEqVar(0),
+		}
+	}
+	return m
+}
+
+func (m *TMap[K, V]) stripeIndex(key K) int {
+	return int(maphash.Comparable(hashSeed, key) % uint64(len(m.stripes)))
+}
+
+func (m *TMap[K, V]) stripe(key K) *tmapStripe[K, V] {
+	return &m.stripes[m.stripeIndex(key)]
+}
+
+func (m *TMap[K, V]) Get(tx *stm.Tx, key K) (V, bool) {
+	return m.stripe(key).m.Get(tx).Get(key)
+}
+
+func (m *TMap[K, V]) Set(tx *stm.Tx, key K, value V) {
+	s := m.stripe(key)
+	sm := s.m.Get(tx)
+	if _, ok := sm.Get(key); !ok {
+		s.len.Set(tx, s.len.Get(tx)+1)
+	}
+	s.m.Set(tx, sm.Set(key, value))
+}
+
+func (m *TMap[K, V]) Delete(tx *stm.Tx, key K) {
+	s := m.stripe(key)
+	sm := s.m.Get(tx)
+	if _, ok := sm.Get(key); !ok {
+		return
+	}
+	s.m.Set(tx, sm.Delete(key))
+	s.len.Set(tx, s.len.Get(tx)-1)
+}
+
+// Len reads the count of every stripe, so it conflicts with insertions and deletions anywhere in
+// the map, but not with writes that replace the value of an existing key.
+func (m *TMap[K, V]) Len(tx *stm.Tx) (n int) {
+	for i := range m.stripes {
+		n += m.stripes[i].len.Get(tx)
+	}
+	return
+}
+
+// All reads every stripe before returning, so the iterator yields the contents of the map as of the
+// transaction, even if it's used after the transaction has moved on.
+func (m *TMap[K, V]) All(tx *stm.Tx) iter.Seq2[K, V] {
+	snapshot := make([]Mappish[K, V], len(m.stripes))
+	for i := range m.stripes {
+		snapshot[i] = m.stripes[i].m.Get(tx)
+	}
+	return func(yield func(K, V) bool) {
+		for _, sm := range snapshot {
+			for k, v := range sm.All() {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package stmutil
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/anacrolix/stm"
+	"github.com/anacrolix/stm/internal/stmtest"
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestTMapGetSetDelete(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewTMap[int, string]()
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			for i := range 100 {
+				m.Set(tx, i, "value")
+			}
+			// Replacing a value doesn't change the length.
+			m.Set(tx, 0, "other")
+		}))
+		qt.Assert(t, qt.Equals(stm.Atomically(m.Len), 100))
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			v, ok := m.Get(tx, 0)
+			qt.Check(t, qt.IsTrue(ok))
+			qt.Check(t, qt.Equals(v, "other"))
+			m.Delete(tx, 50)
+			m.Delete(tx, 1000)
+			_, ok = m.Get(tx, 50)
+			qt.Check(t, qt.IsFalse(ok))
+		}))
+		qt.Check(t, qt.Equals(stm.Atomically(m.Len), 99))
+		seen := make(map[int]bool)
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			for k := range m.All(tx) {
+				seen[k] = true
+			}
+		}))
+		qt.Check(t, qt.HasLen(seen, 99))
+		qt.Check(t, qt.IsFalse(seen[50]))
+	})
+}
+
+// A transaction that read one key isn't rerun because another transaction
+// wrote a key in a different stripe while it was running.
+func TestTMapDisjointKeysDontConflict(t *testing.T) {
+	runIsolated(t, func(t *testing.T) {
+		m := NewTMap[int, int]()
+		a, b := 0, 1
+		for m.stripeIndex(a) == m.stripeIndex(b) {
+			b++
+		}
+		i := stmtest.NewInterrupter(func() {
+			stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+				m.Set(tx, b, 1)
+			}))
+		})
+		stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+			m.Get(tx, a)
+			i.Attempt()
+			m.Set(tx, a, 1)
+		}))
+		qt.Check(t, qt.Equals(i.Attempts, 1))
+	})
+}
+
+func failedCommits() int64 {
+	v, _ := expvar.Get("stm").(*expvar.Map).Get("failed commits").(*expvar.Int)
+	if v == nil {
+		return 0
+	}
+	return v.Value()
+}
+
+// Each goroutine increments its own key, so any failed commit is a conflict
+// between unrelated keys.
+func benchmarkDisjointIncrements(b *testing.B, incr func(tx *stm.Tx, key int)) {
+	b.ReportAllocs()
+	nextKey := stm.NewVar(0)
+	before := failedCommits()
+	b.RunParallel(func(pb *testing.PB) {
+		key := stm.Atomically(func(tx *stm.Tx) int {
+			k := nextKey.Get(tx)
+			nextKey.Set(tx, k+1)
+			return k
+		})
+		for pb.Next() {
+			stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+				incr(tx, key)
+			}))
+		}
+	})
+	b.ReportMetric(float64(failedCommits()-before)/float64(b.N), "conflicts/op")
+}
+
+func BenchmarkTMapDisjointKeys(b *testing.B) {
+	m := NewTMap[int, int]()
+	benchmarkDisjointIncrements(b, func(tx *stm.Tx, key int) {
+		v, _ := m.Get(tx, key)
+		m.Set(tx, key, v+1)
+	})
+}
+
+func BenchmarkVarMappishDisjointKeys(b *testing.B) {
+	m := stm.NewVar(NewMap[int, int]())
+	benchmarkDisjointIncrements(b, func(tx *stm.Tx, key int) {
+		mm := m.Get(tx)
+		v, _ := mm.Get(key)
+		m.Set(tx, mm.Set(key, v+1))
+	})
+}
@@ -0,0 +1,173 @@
+package stmutil
+
+import (
+	"iter"
+	"math/rand/v2"
+
+	"github.com/anacrolix/stm"
+)
+
+// With a quarter of the nodes at each level promoted to the next, this comfortably covers maps of
+// billions of keys.
+const tsortedMapMaxLevel = 16
+
+// A transactional ordered map. It's a skiplist whose links and values are each held in their own
+// Var, so a transaction only conflicts with writes to the parts of the list it actually read. In
+// particular a Range scan reads the links between the keys it visits, and the few links on the way
+// down to the start of the range, and not the rest of the map.
+type TSortedMap[K KeyConstraint, V any] struct {
+	head    tsortedMapNode[K, V]
+	compare func(K, K) int
+}
+
+type tsortedMapNode[K KeyConstraint, V any] struct {
+	key   K
+	value *stm.Var[V]
+	next  []*stm.Var[*tsortedMapNode[K, V]]
+}
+
+func newTSortedMapNode[K KeyConstraint, V any](level int) tsortedMapNode[K, V] {
+	n := tsortedMapNode[K, V]{
+		next: make([]*stm.Var[*tsortedMapNode[K, V]], level),
+	}
+	for i := range n.next {
+		n.next[i] = stm.NewVar[*tsortedMapNode[K, V]](nil)
+	}
+	return n
+}
+
+func NewTSortedMap[K KeyConstraint, V any](less lessFunc[K]) *TSortedMap[K, V] {
+	return &TSortedMap[K, V]{
+		head:    newTSortedMapNode[K, V](tsortedMapMaxLevel),
+		compare: comparer[K]{less}.Compare,
+	}
+}
+
+func randomTSortedMapLevel() (level int) {
+	level = 1
+	for level < tsortedMapMaxLevel && rand.N(4) == 0 {
+		level++
+	}
+	return
+}
+
+// Fills preds with the last node at each level whose key is less than key. The head stands in for
+// there being no such node.
+func (m *TSortedMap[K, V]) findPreds(
+	tx *stm.Tx, key K, preds *[tsortedMapMaxLevel]*tsortedMapNode[K, V],
+) {
+	x := &m.head
+	for l := tsortedMapMaxLevel - 1; l >= 0; l-- {
+		for {
+			next := x.next[l].Get(tx)
+			if next == nil || m.compare(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+		preds[l] = x
+	}
+}
+
+// Returns the last node whose key is less than key, which is the head if there isn't one.
+func (m *TSortedMap[K, V]) findPred(tx *stm.Tx, key K) *tsortedMapNode[K, V] {
+	var preds [tsortedMapMaxLevel]*tsortedMapNode[K, V]
+	m.findPreds(tx, key, &preds)
+	return preds[0]
+}
+
+func (m *TSortedMap[K, V]) Get(tx *stm.Tx, key K) (_ V, ok bool) {
+	n := m.findPred(tx, key).next[0].Get(tx)
+	if n == nil || m.compare(n.key, key) != 0 {
+		return
+	}
+	return n.value.Get(tx), true
+}
+
+func (m *TSortedMap[K, V]) Set(tx *stm.Tx, key K, value V) {
+	var preds [tsortedMapMaxLevel]*tsortedMapNode[K, V]
+	m.findPreds(tx, key, &preds)
+	if n := preds[0].next[0].Get(tx); n != nil && m.compare(n.key, key) == 0 {
+		// Replacing the value leaves the links alone, so it doesn't disturb scans over the key.
+		n.value.Set(tx, value)
+		return
+	}
+	n := newTSortedMapNode[K, V](randomTSortedMapLevel())
+	n.key = key
+	n.value = stm.NewVar(value)
+	for l := range n.next {
+		n.next[l].Set(tx, preds[l].next[l].Get(tx))
+		preds[l].next[l].Set(tx, &n)
+	}
+}
+
+func (m *TSortedMap[K, V]) Delete(tx *stm.Tx, key K) {
+	var preds [tsortedMapMaxLevel]*tsortedMapNode[K, V]
+	m.findPreds(tx, key, &preds)
+	n := preds[0].next[0].Get(tx)
+	if n == nil || m.compare(n.key, key) != 0 {
+		return
+	}
+	for l := range n.next {
+		preds[l].next[l].Set(tx, n.next[l].Get(tx))
+	}
+}
+
+func (m *TSortedMap[K, V]) entry(tx *stm.Tx, n *tsortedMapNode[K, V]) (k K, v V, ok bool) {
+	if n == nil || n == &m.head {
+		return
+	}
+	return n.key, n.value.Get(tx), true
+}
+
+func (m *TSortedMap[K, V]) Min(tx *stm.Tx) (K, V, bool) {
+	return m.entry(tx, m.head.next[0].Get(tx))
+}
+
+func (m *TSortedMap[K, V]) Max(tx *stm.Tx) (K, V, bool) {
+	x := &m.head
+	for l := tsortedMapMaxLevel - 1; l >= 0; l-- {
+		for next := x.next[l].Get(tx); next != nil; next = x.next[l].Get(tx) {
+			x = next
+		}
+	}
+	return m.entry(tx, x)
+}
+
+// Returns the entry with the greatest key less than or equal to key.
+func (m *TSortedMap[K, V]) Floor(tx *stm.Tx, key K) (K, V, bool) {
+	pred := m.findPred(tx, key)
+	if n := pred.next[0].Get(tx); n != nil && m.compare(n.key, key) == 0 {
+		return m.entry(tx, n)
+	}
+	return m.entry(tx, pred)
+}
+
+// Returns the entry with the least key greater than or equal to key.
+func (m *TSortedMap[K, V]) Ceiling(tx *stm.Tx, key K) (K, V, bool) {
+	return m.entry(tx, m.findPred(tx, key).next[0].Get(tx))
+}
+
+// Range iterates in order over the entries with keys from lo up to but not including hi. It reads
+// the map as it goes, so it has to be used inside the transaction, and stopping early leaves the
+// rest of the range out of the transaction's reads.
+func (m *TSortedMap[K, V]) Range(tx *stm.Tx, lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for n := m.findPred(tx, lo).next[0].Get(tx); n != nil; n = n.next[0].Get(tx) {
+			if m.compare(n.key, hi) >= 0 || !yield(n.key, n.value.Get(tx)) {
+				return
+			}
+		}
+	}
+}
+
+// All iterates over every entry in order. Like Range, it has to be used inside the transaction.
+func (m *TSortedMap[K, V]) All(tx *stm.Tx) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for n := m.head.next[0].Get(tx); n != nil; n = n.next[0].Get(tx) {
+			if !yield(n.key, n.value.Get(tx)) {
+				return
+			}
+		}
+	}
+}
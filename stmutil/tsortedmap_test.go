@@ -0,0 +1,105 @@
+package stmutil
+
+import (
+	"testing"
+
+	"github.com/anacrolix/stm"
+	"github.com/anacrolix/stm/internal/stmtest"
+	qt "github.com/go-quicktest/qt"
+)
+
+func intLess(l, r int) bool { return l < r }
+
+func newTestTSortedMap() *TSortedMap[int, string] {
+	m := NewTSortedMap[int, string](intLess)
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		// Every tenth key, inserted out of order.
+		for i := 99; i >= 0; i -= 2 {
+			m.Set(tx, i*10, "value")
+		}
+		for i := 0; i < 100; i += 2 {
+			m.Set(tx, i*10, "value")
+		}
+	}))
+	return m
+}
+
+func TestTSortedMapNavigation(t *testing.T) {
+	m := newTestTSortedMap()
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		k, _, ok := m.Min(tx)
+		qt.Check(t, qt.IsTrue(ok))
+		qt.Check(t, qt.Equals(k, 0))
+		k, _, _ = m.Max(tx)
+		qt.Check(t, qt.Equals(k, 990))
+		k, _, _ = m.Floor(tx, 455)
+		qt.Check(t, qt.Equals(k, 450))
+		k, _, _ = m.Floor(tx, 460)
+		qt.Check(t, qt.Equals(k, 460))
+		_, _, ok = m.Floor(tx, -1)
+		qt.Check(t, qt.IsFalse(ok))
+		k, _, _ = m.Ceiling(tx, 455)
+		qt.Check(t, qt.Equals(k, 460))
+		_, _, ok = m.Ceiling(tx, 991)
+		qt.Check(t, qt.IsFalse(ok))
+		var keys []int
+		for k := range m.Range(tx, 100, 150) {
+			keys = append(keys, k)
+		}
+		qt.Check(t, qt.DeepEquals(keys, []int{100, 110, 120, 130, 140}))
+	}))
+}
+
+func TestTSortedMapGetSetDelete(t *testing.T) {
+	m := newTestTSortedMap()
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		m.Set(tx, 500, "other")
+		m.Delete(tx, 510)
+		m.Delete(tx, 511)
+		m.Set(tx, 515, "new")
+	}))
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		v, ok := m.Get(tx, 500)
+		qt.Check(t, qt.IsTrue(ok))
+		qt.Check(t, qt.Equals(v, "other"))
+		_, ok = m.Get(tx, 510)
+		qt.Check(t, qt.IsFalse(ok))
+		v, _ = m.Get(tx, 515)
+		qt.Check(t, qt.Equals(v, "new"))
+		prev := -1
+		n := 0
+		for k := range m.All(tx) {
+			qt.Check(t, qt.IsTrue(k > prev))
+			prev = k
+			n++
+		}
+		qt.Check(t, qt.Equals(n, 100))
+	}))
+}
+
+// Runs a transaction that scans [lo, hi) and is interrupted once by write.
+// Returns how many times the scan ran.
+func scanAttempts(m *TSortedMap[int, string], lo, hi int, write func(tx *stm.Tx)) int {
+	i := stmtest.NewInterrupter(func() {
+		stm.Atomically(stm.VoidOperation(write))
+	})
+	stm.Atomically(stm.VoidOperation(func(tx *stm.Tx) {
+		for range m.Range(tx, lo, hi) {
+		}
+		i.Attempt()
+	}))
+	return i.Attempts
+}
+
+func TestTSortedMapRangeConflicts(t *testing.T) {
+	m := newTestTSortedMap()
+	qt.Check(t, qt.Equals(scanAttempts(m, 200, 300, func(tx *stm.Tx) {
+		m.Set(tx, 800, "outside")
+	}), 1))
+	qt.Check(t, qt.Equals(scanAttempts(m, 200, 300, func(tx *stm.Tx) {
+		m.Set(tx, 250, "inside")
+	}), 2))
+	qt.Check(t, qt.Equals(scanAttempts(m, 200, 300, func(tx *stm.Tx) {
+		m.Set(tx, 255, "inserted")
+	}), 2))
+}
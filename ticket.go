@@ -0,0 +1,68 @@
+package stm
+
+// Version returns the number of commits to v before the value the transaction reads. It doesn't
+// count the transaction's own writes.
+func (v *Var[T]) Version(tx *Tx) uint64 {
+	return uint64(tx.readVersion(v))
+}
+
+// AtomicVersion returns the number of commits to v so far.
+func AtomicVersion[T any](v *Var[T]) uint64 {
+	return uint64(v.value.Load().(versioner).varVersion())
+}
+
+// A Ticket remembers a version of a Var, so that a later transaction can check that the Var hasn't
+// been committed to since. This detects conflicts over spans of time that a transaction can't
+// cover, like a user editing a form, or between HTTP requests with the version as an ETag.
+type Ticket struct {
+	v       txVar
+	version uint64
+}
+
+// AtomicTicket reads v, and returns a Ticket for the version read.
+func AtomicTicket[T any](v *Var[T]) (T, Ticket) {
+	vv := v.value.Load()
+	return fromAny[T](vv.Get()), Ticket{v, uint64(vv.(versioner).varVersion())}
+}
+
+// TicketAt returns a Ticket for the given version of v, such as one got from Version, or
+// Ticket.Version by way of an ETag.
+func (v *Var[T]) TicketAt(version uint64) Ticket {
+	return Ticket{v, version}
+}
+
+func (t Ticket) Version() uint64 {
+	return t.version
+}
+
+// Validate reports whether the Var for the Ticket is still at the Ticket's version, as of the start
+// of the transaction. The transaction depends on the Var, so the result holds when it commits.
+func (tx *Tx) Validate(t Ticket) bool {
+	return uint64(tx.readVersion(t.v)) == t.version
+}
+
+// Returns the version of v that the transaction reads, and makes the transaction depend on it. A
+// Var made with a comparison only counts changes to its value as changes, so the read is replaced
+// with a versionRead that counts every commit.
+func (tx *Tx) readVersion(v txVar) version {
+	vv := tx.readVarValue(v)
+	vr, ok := vv.(versionRead)
+	if !ok {
+		vr = versionRead{vv}
+		tx.reads[v] = vr
+	}
+	return vr.varVersion()
+}
+
+// A read that the transaction depends on the version of, and not just the value.
+type versionRead struct {
+	VarValue
+}
+
+func (me versionRead) Changed(other VarValue) bool {
+	return me.varVersion() != other.(versioner).varVersion()
+}
+
+func (me versionRead) varVersion() version {
+	return me.VarValue.(versioner).varVersion()
+}
@@ -0,0 +1,71 @@
+package stm
+
+import (
+	"testing"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestVarVersion(t *testing.T) {
+	x := NewVar("a")
+	qt.Check(t, qt.Equals(AtomicVersion(x), 0))
+	AtomicSet(x, "b")
+	qt.Check(t, qt.Equals(AtomicVersion(x), 1))
+	Atomically(VoidOperation(func(tx *Tx) {
+		x.Set(tx, "c")
+		qt.Check(t, qt.Equals(x.Version(tx), 1))
+	}))
+	qt.Check(t, qt.Equals(Atomically(x.Version), 2))
+}
+
+func TestTicket(t *testing.T) {
+	x := NewBuiltinEqVar(1)
+	v, ticket := AtomicTicket(x)
+	qt.Check(t, qt.Equals(v, 1))
+	update := func(ticket Ticket, n int) bool {
+		return Atomically(func(tx *Tx) bool {
+			if !tx.Validate(ticket) {
+				return false
+			}
+			x.Set(tx, n)
+			return true
+		})
+	}
+	qt.Check(t, qt.IsTrue(update(ticket, 2)))
+	// The Var has been committed to since the ticket was taken.
+	qt.Check(t, qt.IsFalse(update(ticket, 3)))
+	qt.Check(t, qt.Equals(AtomicGet(x), 2))
+	// A ticket can be rebuilt from a version passed around elsewhere.
+	qt.Check(t, qt.IsTrue(update(x.TicketAt(ticket.Version()+1), 4)))
+	qt.Check(t, qt.Equals(AtomicGet(x), 4))
+}
+
+// A commit that leaves the value of a Var made with a comparison the same still moves it past the
+// Ticket, and invalidates a transaction that validated the Ticket before it.
+func TestTicketCustomVar(t *testing.T) {
+	x := NewBuiltinEqVar(1)
+	_, ticket := AtomicTicket(x)
+	attempts := 0
+	valid := Atomically(func(tx *Tx) bool {
+		attempts++
+		valid := tx.Validate(ticket)
+		if attempts == 1 {
+			AtomicSet(x, 1)
+		}
+		return valid
+	})
+	qt.Check(t, qt.IsFalse(valid))
+	qt.Check(t, qt.Equals(attempts, 2))
+	// Version makes the transaction depend on the version in the same way.
+	attempts = 0
+	version := Atomically(func(tx *Tx) uint64 {
+		attempts++
+		version := x.Version(tx)
+		if attempts == 1 {
+			AtomicSet(x, 1)
+		}
+		return version
+	})
+	qt.Check(t, qt.Equals(version, 2))
+	qt.Check(t, qt.Equals(attempts, 2))
+}
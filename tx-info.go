@@ -0,0 +1,46 @@
+package stm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var lastTxID atomic.Uint64
+
+// ID identifies the transaction. It stays the same when the transaction is retried, and isn't
+// shared with any other transaction run by this process.
+func (tx *Tx) ID() uint64 {
+	if id := tx.id.Load(); id != 0 {
+		return id
+	}
+	// Only transactions that are asked for an ID take one from the shared counter.
+	tx.id.CompareAndSwap(0, lastTxID.Add(1))
+	return tx.id.Load()
+}
+
+// Attempt returns how many times the transaction has been run, including the current run. It's 1
+// the first time through an operation.
+func (tx *Tx) Attempt() int {
+	return tx.tries
+}
+
+// Elapsed returns the time since the transaction began its first attempt.
+func (tx *Tx) Elapsed() time.Duration {
+	if tx.started.IsZero() {
+		return 0
+	}
+	return time.Since(tx.started)
+}
+
+// Records the start of the first attempt. Transactions that are rerun by the same function, like
+// those of Watch, keep the time they were first started.
+func (tx *Tx) start() {
+	if tx.started.IsZero() {
+		tx.started = time.Now()
+	}
+}
+
+func (tx *Tx) String() string {
+	return fmt.Sprintf("stm.Tx %v (attempt %v, %v)", tx.ID(), tx.tries, tx.Elapsed())
+}
@@ -0,0 +1,49 @@
+package stm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestTxIdentityAcrossRetries(t *testing.T) {
+	x := NewVar(0)
+	var ids []uint64
+	var attempts []int
+	var elapsed []time.Duration
+	var s string
+	Atomically(VoidOperation(func(tx *Tx) {
+		ids = append(ids, tx.ID())
+		attempts = append(attempts, tx.Attempt())
+		elapsed = append(elapsed, tx.Elapsed())
+		if x.Get(tx) == 0 {
+			time.Sleep(time.Millisecond)
+			AtomicSet(x, 1)
+		}
+		s = tx.String()
+	}))
+	qt.Assert(t, qt.HasLen(ids, 2))
+	qt.Check(t, qt.Equals(ids[1], ids[0]))
+	qt.Check(t, qt.DeepEquals(attempts, []int{1, 2}))
+	qt.Check(t, qt.IsTrue(elapsed[1] >= time.Millisecond))
+	qt.Check(t, qt.Matches(s, fmt.Sprintf(`stm\.Tx %v \(attempt 2, .+\)`, ids[0])))
+	// Another transaction gets another ID.
+	other := Atomically(func(tx *Tx) uint64 {
+		return tx.ID()
+	})
+	qt.Check(t, qt.Not(qt.Equals(other, ids[0])))
+}
+
+// Transactions that aren't asked for an ID don't take one from the shared counter.
+func TestTxIDAssignedLazily(t *testing.T) {
+	before := lastTxID.Load()
+	Atomically(VoidOperation(func(tx *Tx) {}))
+	WouldBlock(VoidOperation(func(tx *Tx) {}))
+	qt.Check(t, qt.Equals(lastTxID.Load(), before))
+	Atomically(VoidOperation(func(tx *Tx) {
+		tx.ID()
+	}))
+	qt.Check(t, qt.Equals(lastTxID.Load(), before+1))
+}
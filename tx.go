@@ -2,17 +2,73 @@ package stm
 
 import (
 	"cmp"
-	"fmt"
+	"context"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+// TxVar is what a transaction needs of the cells it reads and writes, for implementations other
+// than Var to take part in transactions alongside Vars through ReadTxVar and WriteTxVar.
+type TxVar interface {
+	// Returns the latest committed value. It's called without the commit lock held, and the
+	// VarValue's Changed method is what tells transactions whether they're still valid.
+	LoadVarValue() VarValue
+	// Installs a value written by a committing transaction, with the commit lock held. If the new
+	// VarValue has Changed from the old one, it must be visible to LoadVarValue before the
+	// watchers are woken with Watchers.Wake.
+	CommitValue(any)
+	// Held while transactions that involve the TxVar are validated and committed.
+	CommitLock() *sync.Mutex
+	// The transactions waiting on the TxVar to change.
+	Watchers() *Watchers
+}
+
+// TxVar as transactions use it. Var implements it without exporting the methods, as nothing but the
+// transaction machinery should be able to commit to a Var. Other TxVars are wrapped in
+// externalTxVar.
 type txVar interface {
-	getValue() *atomicValue[VarValue]
-	changeValue(any)
-	getWatchers() *sync.Map
-	getLock() *sync.Mutex
+	currentVarValue() VarValue
+	commitValue(any)
+	// commitValue for a transaction's commit, between beginCommit and endCommit.
+	commitValueAt(value any, at uint64)
+	commitMutex() *sync.Mutex
+	txWatchers() *Watchers
+}
+
+type externalTxVar struct {
+	TxVar
+}
+
+func (me externalTxVar) currentVarValue() VarValue {
+	return me.LoadVarValue()
+}
+
+func (me externalTxVar) commitValue(val any) {
+	me.CommitValue(val)
+}
+
+// Other TxVars aren't read from snapshots, so the commit number is no use to them.
+func (me externalTxVar) commitValueAt(val any, at uint64) {
+	me.CommitValue(val)
+}
+
+func (me externalTxVar) commitMutex() *sync.Mutex {
+	return me.CommitLock()
+}
+
+func (me externalTxVar) txWatchers() *Watchers {
+	return me.Watchers()
+}
+
+// Returns the TxVar, or Var, that v is for users.
+func publicTxVar(v txVar) any {
+	if e, ok := v.(externalTxVar); ok {
+		return e.TxVar
+	}
+	return v
 }
 
 // A Tx represents an atomic transaction.
@@ -27,35 +83,74 @@ type Tx struct {
 	completed      bool
 	tries          int
 	numRetryValues int
+	// Assigned when it's first asked for.
+	id atomic.Uint64
+	// Set by the functions that run operations, and not for transactions used internally.
+	started time.Time
+	// Set for transactions run by AtomicallyReadOnly, which read Vars as of snapshot.
+	readOnly bool
+	snapshot uint64
+	// Set for transactions run by OnReady, to be called instead of signalling cond when the
+	// transaction is waiting and one of its inputs changes.
+	onWake func()
+	// Transaction-local values, see Tx.Value.
+	locals map[any]any
+	// Timers for RetryUntil, kept across attempts.
+	deadlines map[time.Time]deadlineTimer
 }
 
 // Check that none of the logged values have changed since the transaction began.
 func (tx *Tx) inputsChanged() bool {
+	return tx.changedInput() != nil
+}
+
+// Returns a TxVar that has changed since the transaction read it, or nil.
+func (tx *Tx) changedInput() txVar {
 	for v, read := range tx.reads {
-		if read.Changed(v.getValue().Load()) {
-			return true
+		if read.Changed(v.currentVarValue()) {
+			return v
 		}
 	}
-	return false
+	return nil
 }
 
 // Writes the values in the transaction log to their respective Vars.
 func (tx *Tx) commit() {
+	// The writes to Vars are given one commit number, so snapshots see all of them or none.
+	at, numbered := beginCommit()
+	if !numbered {
+		for v, val := range tx.writes {
+			v.commitValueAt(val, at)
+		}
+		return
+	}
+	func() {
+		defer endCommit()
+		for v, val := range tx.writes {
+			if _, ok := v.(externalTxVar); !ok {
+				v.commitValueAt(val, at)
+			}
+		}
+	}()
+	// Other TxVars are committed outside, in case they commit to Vars of their own.
 	for v, val := range tx.writes {
-		v.changeValue(val)
+		if _, ok := v.(externalTxVar); ok {
+			v.commitValue(val)
+		}
 	}
 }
 
 func (tx *Tx) updateWatchers() {
+	tx.stopUnreadDeadlines()
 	for v := range tx.watching {
 		if _, ok := tx.reads[v]; !ok {
 			delete(tx.watching, v)
-			v.getWatchers().Delete(tx)
+			v.txWatchers().remove(tx)
 		}
 	}
 	for v := range tx.reads {
 		if _, ok := tx.watching[v]; !ok {
-			v.getWatchers().Store(tx, nil)
+			v.txWatchers().add(tx)
 			tx.watching[v] = struct{}{}
 		}
 	}
@@ -63,13 +158,36 @@ func (tx *Tx) updateWatchers() {
 
 // wait blocks until another transaction modifies any of the Vars read by tx.
 func (tx *Tx) wait() {
+	tx.waitUntil(nil, time.Time{})
+}
+
+// waitUntil is wait that gives up when ctx is done, unless it's nil, or at deadline, unless it's
+// zero. Returns false if it gave up.
+func (tx *Tx) waitUntil(ctx context.Context, deadline time.Time) bool {
 	if len(tx.reads) == 0 {
 		panic("not waiting on anything")
 	}
 	tx.updateWatchers()
 	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	interrupted := false
+	interrupt := func() {
+		tx.mu.Lock()
+		interrupted = true
+		tx.cond.Broadcast()
+		tx.mu.Unlock()
+	}
+	if !deadline.IsZero() {
+		defer time.AfterFunc(time.Until(deadline), interrupt).Stop()
+	}
+	if ctx != nil {
+		defer context.AfterFunc(ctx, interrupt)()
+	}
 	firstWait := true
 	for !tx.inputsChanged() {
+		if interrupted {
+			return false
+		}
 		if !firstWait {
 			expvars.Add("wakes for unchanged versions", 1)
 		}
@@ -80,22 +198,41 @@ func (tx *Tx) wait() {
 		tx.waiting = false
 		firstWait = false
 	}
-	tx.mu.Unlock()
+	return true
 }
 
 // Get returns the value of v as of the start of the transaction.
 func (v *Var[T]) Get(tx *Tx) T {
+	return fromAny[T](readTxVar(tx, v))
+}
+
+// ReadTxVar returns the value of v as of the start of the transaction, and records the read.
+func ReadTxVar(tx *Tx, v TxVar) any {
+	return readTxVar(tx, externalTxVar{v})
+}
+
+func readTxVar(tx *Tx, v txVar) any {
 	// If we previously wrote to v, it will be in the write log.
 	if val, ok := tx.writes[v]; ok {
-		return fromAny[T](val)
+		return val
 	}
+	return tx.readVarValue(v).Get()
+}
+
+// Returns the VarValue of v that the transaction reads, and makes the transaction depend on it.
+func (tx *Tx) readVarValue(v txVar) VarValue {
 	// If we haven't previously read v, record its version
 	vv, ok := tx.reads[v]
 	if !ok {
-		vv = v.getValue().Load()
+		vv = tx.loadVarValue(v)
+		tx.reads[v] = vv
+	} else if pr, ok := vv.(projectedRead); ok {
+		// We only depended on projections of the value until now. Keep the value they were made
+		// from, so the transaction sees one version of the Var throughout.
+		vv = pr.VarValue
 		tx.reads[v] = vv
 	}
-	return fromAny[T](vv.Get())
+	return vv
 }
 
 // Set sets the value of a Var for the lifetime of the transaction.
@@ -103,6 +240,19 @@ func (v *Var[T]) Set(tx *Tx, val T) {
 	if v == nil {
 		panic("nil Var")
 	}
+	writeTxVar(tx, v, val)
+}
+
+// WriteTxVar sets the value of v for the lifetime of the transaction. It's passed to v's
+// CommitValue if the transaction commits.
+func WriteTxVar(tx *Tx, v TxVar, val any) {
+	writeTxVar(tx, externalTxVar{v}, val)
+}
+
+func writeTxVar(tx *Tx, v txVar, val any) {
+	if tx.readOnly {
+		panic("write in read-only transaction")
+	}
 	tx.writes[v] = val
 }
 
@@ -131,6 +281,7 @@ func (tx *Tx) reset() {
 	tx.mu.Lock()
 	clear(tx.reads)
 	clear(tx.writes)
+	clear(tx.locals)
 	tx.mu.Unlock()
 	tx.removeRetryProfiles()
 	tx.resetLocks()
@@ -146,9 +297,10 @@ func (tx *Tx) removeRetryProfiles() {
 func (tx *Tx) recycle() {
 	for v := range tx.watching {
 		delete(tx.watching, v)
-		v.getWatchers().Delete(tx)
+		v.txWatchers().remove(tx)
 	}
 	tx.removeRetryProfiles()
+	tx.stopDeadlines()
 	// I don't think we can reuse Txs, because the "completed" field should/needs to be set
 	// indefinitely after use.
 	//txPool.Put(tx)
@@ -167,7 +319,7 @@ func (tx *Tx) resetLocks() {
 
 func (tx *Tx) collectReadLocks() {
 	for v := range tx.reads {
-		tx.locks.append(v.getLock())
+		tx.locks.append(v.commitMutex())
 	}
 }
 
@@ -175,7 +327,7 @@ func (tx *Tx) collectAllLocks() {
 	tx.collectReadLocks()
 	for v := range tx.writes {
 		if _, ok := tx.reads[v]; !ok {
-			tx.locks.append(v.getLock())
+			tx.locks.append(v.commitMutex())
 		}
 	}
 }
@@ -196,19 +348,17 @@ func (tx *Tx) unlock() {
 	}
 }
 
-func (tx *Tx) String() string {
-	return fmt.Sprintf("%[1]T %[1]p", tx)
-}
-
 type txLocks struct {
 	mus []*sync.Mutex
 }
 
 // Sorts the locks by address, to establish a consistent acquisition order across transactions.
+// TxVars other than Var can share a lock, so duplicates are dropped too.
 func (me *txLocks) sort() {
 	slices.SortFunc(me.mus, func(i, j *sync.Mutex) int {
 		return cmp.Compare(uintptr(unsafe.Pointer(i)), uintptr(unsafe.Pointer(j)))
 	})
+	me.mus = slices.Compact(me.mus)
 }
 
 func (me *txLocks) clear() {
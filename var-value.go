@@ -8,6 +8,12 @@ type VarValue interface {
 
 type version uint64
 
+// Implemented by the VarValues of Vars, which count the commits to the Var whether or not they use
+// the count to tell when the value has changed.
+type versioner interface {
+	varVersion() version
+}
+
 // Values are held in an any so that a Tx can log Vars of every type together. A T that is an
 // interface type holding nil boxes into a nil any, which a plain type assertion rejects, so use the
 // comma-ok form and let the zero value stand for it. A Var[T] is only ever given a T, so there is
@@ -37,9 +43,14 @@ func (me versionedValue[T]) Changed(other VarValue) bool {
 	return me.version != other.(versionedValue[T]).version
 }
 
+func (me versionedValue[T]) varVersion() version {
+	return me.version
+}
+
 type customVarValue[T any] struct {
 	value   T
 	changed func(T, T) bool
+	version version
 }
 
 var _ VarValue = customVarValue[struct{}]{}
@@ -52,9 +63,14 @@ func (me customVarValue[T]) Set(newValue any) VarValue {
 	return customVarValue[T]{
 		value:   fromAny[T](newValue),
 		changed: me.changed,
+		version: me.version + 1,
 	}
 }
 
+func (me customVarValue[T]) varVersion() version {
+	return me.version
+}
+
 func (me customVarValue[T]) Get() any {
 	return me.value
 }
@@ -2,73 +2,150 @@ package stm
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Holds an STM variable.
 type Var[T any] struct {
 	value    atomicValue[VarValue]
-	watchers sync.Map
+	watchers Watchers
 	mu       sync.Mutex
+	// Guarded by mu. Nil unless the Var was made WithHistory.
+	history *varHistory[T]
+	// Older values kept for snapshots, newest first, or nil if the current value is all there is.
+	versions atomic.Pointer[varVersion]
+	// Guarded by mu. The commit that installed the current value.
+	committedAt uint64
 }
 
-func (v *Var[T]) getValue() *atomicValue[VarValue] {
-	return &v.value
+func (v *Var[T]) currentVarValue() VarValue {
+	return v.value.Load()
 }
 
-func (v *Var[T]) getWatchers() *sync.Map {
+func (v *Var[T]) txWatchers() *Watchers {
 	return &v.watchers
 }
 
-func (v *Var[T]) getLock() *sync.Mutex {
+func (v *Var[T]) commitMutex() *sync.Mutex {
 	return &v.mu
 }
 
-func (v *Var[T]) changeValue(new any) {
-	old := v.value.Load()
-	newVarValue := old.Set(new)
-	v.value.Store(newVarValue)
-	if old.Changed(newVarValue) {
-		go v.wakeWatchers(newVarValue)
+func (v *Var[T]) commitValue(new any) {
+	at, numbered := beginCommit()
+	if numbered {
+		defer endCommit()
 	}
+	v.commitValueAt(new, at)
 }
 
-func (v *Var[T]) wakeWatchers(new VarValue) {
-	v.watchers.Range(func(k, _ any) bool {
+// The transactions waiting on a TxVar. The zero value is ready to use.
+type Watchers struct {
+	txs sync.Map
+}
+
+func (w *Watchers) add(tx *Tx) {
+	w.txs.Store(tx, nil)
+}
+
+func (w *Watchers) remove(tx *Tx) {
+	w.txs.Delete(tx)
+}
+
+// Wake wakes, in the background, the transactions waiting on v that read a value that new has
+// Changed from. It's to be called by v's CommitValue.
+func (w *Watchers) Wake(v TxVar, new VarValue) {
+	w.wake(externalTxVar{v}, new)
+}
+
+func (w *Watchers) wake(v txVar, new VarValue) {
+	go w.wakeWatchers(v, new)
+}
+
+func (w *Watchers) wakeWatchers(v txVar, new VarValue) {
+	w.txs.Range(func(k, _ any) bool {
 		tx := k.(*Tx)
 		// We have to lock here to ensure that the Tx is waiting before we signal it. Otherwise we
 		// could signal it before it goes to sleep and it will miss the notification.
 		tx.mu.Lock()
+		var wake func()
 		if read := tx.reads[v]; read != nil && read.Changed(new) {
-			tx.cond.Broadcast()
-			for !tx.waiting && !tx.completed {
-				tx.cond.Wait()
+			if tx.onWake != nil {
+				// An async transaction that isn't waiting is running, and will see the change
+				// itself.
+				if tx.waiting {
+					tx.waiting = false
+					wake = tx.onWake
+				}
+			} else {
+				tx.cond.Broadcast()
+				for !tx.waiting && !tx.completed {
+					tx.cond.Wait()
+				}
 			}
 		}
 		tx.mu.Unlock()
-		return !v.value.Load().Changed(new)
+		if wake != nil {
+			wake()
+		}
+		return !v.currentVarValue().Changed(new)
 	})
 }
 
 // Returns a new STM variable.
-func NewVar[T any](val T) *Var[T] {
-	v := &Var[T]{}
-	v.value.Store(versionedValue[T]{
+func NewVar[T any](val T, opts ...VarOption) *Var[T] {
+	return newVar[T](versionedValue[T]{
 		value: val,
-	})
-	return v
+	}, opts)
 }
 
-func NewCustomVar[T any](val T, changed func(T, T) bool) *Var[T] {
-	v := &Var[T]{}
-	v.value.Store(customVarValue[T]{
+func NewCustomVar[T any](val T, changed func(T, T) bool, opts ...VarOption) *Var[T] {
+	return newVar[T](customVarValue[T]{
 		value:   val,
 		changed: changed,
-	})
-	return v
+	}, opts)
 }
 
-func NewBuiltinEqVar[T comparable](val T) *Var[T] {
+func NewBuiltinEqVar[T comparable](val T, opts ...VarOption) *Var[T] {
 	return NewCustomVar(val, func(a, b T) bool {
 		return a != b
-	})
+	}, opts...)
+}
+
+func newVar[T any](value VarValue, opts []VarOption) *Var[T] {
+	var o varOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	v := &Var[T]{}
+	v.value.Store(value)
+	if o.historyLen > 0 {
+		v.history = &varHistory[T]{max: o.historyLen}
+		v.history.record(value, time.Now())
+	}
+	return v
+}
+
+// The reading half of a Var, for handing state to code that shouldn't be able to change it.
+type ReadOnlyVar[T any] interface {
+	Get(*Tx) T
+}
+
+var (
+	_ ReadOnlyVar[struct{}] = (*Var[struct{}])(nil)
+	_ ReadOnlyVar[struct{}] = (*DerivedVar[struct{}])(nil)
+)
+
+type constVar[T any] struct {
+	value T
+}
+
+// Returns a ReadOnlyVar that always holds val. Reading it adds nothing to the transaction, as
+// there's nothing that could change, so it's never locked or watched, and can be shared freely.
+func Const[T any](val T) ReadOnlyVar[T] {
+	return constVar[T]{val}
+}
+
+func (me constVar[T]) Get(*Tx) T {
+	return me.value
 }
@@ -0,0 +1,50 @@
+package stm
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// Watch returns a sequence of the results of op, run as by Atomically: the first now, and another
+// each time a Var it read changes, waiting in between as Retry does. It ends when ctx is done, and
+// waits for op to succeed if it calls Retry. op shouldn't write to Vars it reads, or there's always
+// another result.
+func Watch[R any](ctx context.Context, op Operation[R]) iter.Seq[R] {
+	return func(yield func(R) bool) {
+		// One transaction throughout, see atomicallyTx.
+		tx := newTx()
+		defer tx.recycle()
+		for {
+			ret, err := atomicallyTx(tx, op, atomicallyOptions{ctx: ctx})
+			if err != nil || !yield(ret) {
+				return
+			}
+			if len(tx.reads) == 0 {
+				// Nothing can change the result.
+				<-ctx.Done()
+				return
+			}
+			if !tx.waitUntil(ctx, time.Time{}) || ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// WatchChan is Watch delivering results on a channel, which is closed when ctx is done. Each result
+// is computed once the previous one is received, so states in between are skipped.
+func WatchChan[R any](ctx context.Context, op Operation[R]) <-chan R {
+	ch := make(chan R)
+	go func() {
+		defer close(ch)
+		for ret := range Watch(ctx, op) {
+			select {
+			case ch <- ret:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,89 @@
+package stm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/go-quicktest/qt"
+)
+
+func TestWatch(t *testing.T) {
+	x, other := NewVar(1), NewVar(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []int
+	for r := range Watch(ctx, func(tx *Tx) int {
+		cur := x.Get(tx)
+		// Waits for odd values.
+		tx.Assert(cur%2 != 0)
+		return cur
+	}) {
+		got = append(got, r)
+		if r == 5 {
+			break
+		}
+		go func() {
+			AtomicSet(other, r)
+			AtomicSet(x, r+1)
+			AtomicSet(x, r+2)
+		}()
+	}
+	qt.Check(t, qt.DeepEquals(got, []int{1, 3, 5}))
+}
+
+func TestWatchCancel(t *testing.T) {
+	x := NewVar(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := WatchChan(ctx, func(tx *Tx) int {
+		return x.Get(tx)
+	})
+	qt.Check(t, qt.Equals(<-ch, 0))
+	AtomicSet(x, 1)
+	qt.Check(t, qt.Equals(<-ch, 1))
+	cancel()
+	select {
+	case _, ok := <-ch:
+		qt.Check(t, qt.IsFalse(ok))
+	case <-time.After(time.Second):
+		t.Fatal("channel wasn't closed")
+	}
+	qt.Check(t, qt.Equals(numWatchers(x), 0))
+}
+
+// Cancelling ends a Watch that's waiting for op to stop retrying.
+func TestWatchCancelRetry(t *testing.T) {
+	x := NewVar(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := WatchChan(ctx, func(tx *Tx) int {
+		x.Get(tx)
+		tx.Retry()
+		return 0
+	})
+	time.AfterFunc(time.Millisecond, cancel)
+	_, ok := <-ch
+	qt.Check(t, qt.IsFalse(ok))
+}
+
+// A RetryUntil in a Select branch that didn't retry still wakes the Watch at the deadline.
+func TestWatchRetryUntil(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deadline := time.Now().Add(20 * time.Millisecond)
+	ch := WatchChan(ctx, Select(
+		func(tx *Tx) string {
+			tx.RetryUntil(deadline)
+			return "expired"
+		},
+		func(tx *Tx) string {
+			return "pending"
+		},
+	))
+	qt.Check(t, qt.Equals(<-ch, "pending"))
+	select {
+	case r := <-ch:
+		qt.Check(t, qt.Equals(r, "expired"))
+	case <-time.After(time.Second):
+		t.Fatal("the deadline didn't wake the watch")
+	}
+}